@@ -5,6 +5,8 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
 
 	"shellshift/internal/db"
 	"shellshift/internal/factory"
@@ -36,8 +38,28 @@ func main() {
 
 	protector := auth.NewProtectionMiddleware(authURI, secretKey)
 
+	trashRetentionDays := 30
+	if days := os.Getenv("TRASH_RETENTION_DAYS"); days != "" {
+		parsed, err := strconv.Atoi(days)
+		if err != nil || parsed < 1 {
+			panic("TRASH_RETENTION_DAYS should be a positive number")
+		}
+		trashRetentionDays = parsed
+	}
+	trashRetention := time.Duration(trashRetentionDays) * 24 * time.Hour
+
+	// Instances behind the same balancer should share generated streams
+	var sharedStreams bool
+	switch broker := os.Getenv("STREAM_BROKER"); broker {
+	case "", "memory":
+	case "db":
+		sharedStreams = true
+	default:
+		panic(fmt.Sprintf("unknown STREAM_BROKER %q, should be memory or db", broker))
+	}
+
 	m.Handle("/", http.RedirectHandler("/auth/login", http.StatusMovedPermanently))
-	m.Handle(fmt.Sprintf("%s/", chatURI), http.StripPrefix(chatURI, chat.InitMux(dbFactory, protector, chatURI, graphURI)))
+	m.Handle(fmt.Sprintf("%s/", chatURI), http.StripPrefix(chatURI, chat.InitMux(dbFactory, protector, chatURI, graphURI, trashRetention, sharedStreams)))
 	m.Handle(fmt.Sprintf("%s/", graphURI), http.StripPrefix(graphURI, graph.InitMux(dbFactory, protector, chatURI)))
 	m.Handle(fmt.Sprintf("%s/", authURI), http.StripPrefix(authURI, auth.InitMux(q, protector, secretKey, authURI, chatURI)))
 
@@ -7,6 +7,7 @@ package db
 
 import (
 	"context"
+	"database/sql"
 )
 
 const deleteChat = `-- name: DeleteChat :exec
@@ -14,6 +15,7 @@ DELETE FROM
     chat
 WHERE
     id = ?
+    AND deleted_at IS NOT NULL
 `
 
 func (q *Queries) DeleteChat(ctx context.Context, id string) error {
@@ -42,7 +44,10 @@ func (q *Queries) DeleteTag(ctx context.Context, arg DeleteTagParams) error {
 const findChat = `-- name: FindChat :one
 SELECT
     title,
-    messages
+    deleted_at,
+    pinned_at,
+    archived_at,
+    version
 FROM
     chat
 WHERE
@@ -50,20 +55,35 @@ WHERE
 `
 
 type FindChatRow struct {
-	Title    string
-	Messages []byte
+	Title      string
+	DeletedAt  sql.NullInt64
+	PinnedAt   sql.NullInt64
+	ArchivedAt sql.NullInt64
+	Version    int64
 }
 
 func (q *Queries) FindChat(ctx context.Context, id string) (FindChatRow, error) {
 	row := q.db.QueryRowContext(ctx, findChat, id)
 	var i FindChatRow
-	err := row.Scan(&i.Title, &i.Messages)
+	err := row.Scan(
+		&i.Title,
+		&i.DeletedAt,
+		&i.PinnedAt,
+		&i.ArchivedAt,
+		&i.Version,
+	)
 	return i, err
 }
 
 const findChatBranch = `-- name: FindChatBranch :one
 SELECT
-    messages
+    updated_at,
+    deleted_at,
+    name,
+    description,
+    abandoned_at,
+    origin,
+    version
 FROM
     chat_branch
 WHERE
@@ -76,35 +96,75 @@ type FindChatBranchParams struct {
 	ID     string
 }
 
-func (q *Queries) FindChatBranch(ctx context.Context, arg FindChatBranchParams) ([]byte, error) {
+type FindChatBranchRow struct {
+	UpdatedAt   int64
+	DeletedAt   sql.NullInt64
+	Name        string
+	Description string
+	AbandonedAt sql.NullInt64
+	Origin      int64
+	Version     int64
+}
+
+func (q *Queries) FindChatBranch(ctx context.Context, arg FindChatBranchParams) (FindChatBranchRow, error) {
 	row := q.db.QueryRowContext(ctx, findChatBranch, arg.ChatID, arg.ID)
-	var messages []byte
-	err := row.Scan(&messages)
-	return messages, err
+	var i FindChatBranchRow
+	err := row.Scan(
+		&i.UpdatedAt,
+		&i.DeletedAt,
+		&i.Name,
+		&i.Description,
+		&i.AbandonedAt,
+		&i.Origin,
+		&i.Version,
+	)
+	return i, err
 }
 
 const findChatBranches = `-- name: FindChatBranches :many
 SELECT
-    id
+    id,
+    updated_at,
+    name,
+    description,
+    abandoned_at,
+    origin
 FROM
     chat_branch
 WHERE
     chat_id = ?
+    AND deleted_at IS NULL
 `
 
-func (q *Queries) FindChatBranches(ctx context.Context, chatID string) ([]string, error) {
+type FindChatBranchesRow struct {
+	ID          string
+	UpdatedAt   int64
+	Name        string
+	Description string
+	AbandonedAt sql.NullInt64
+	Origin      int64
+}
+
+func (q *Queries) FindChatBranches(ctx context.Context, chatID string) ([]FindChatBranchesRow, error) {
 	rows, err := q.db.QueryContext(ctx, findChatBranches, chatID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []string
+	var items []FindChatBranchesRow
 	for rows.Next() {
-		var id string
-		if err := rows.Scan(&id); err != nil {
+		var i FindChatBranchesRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.UpdatedAt,
+			&i.Name,
+			&i.Description,
+			&i.AbandonedAt,
+			&i.Origin,
+		); err != nil {
 			return nil, err
 		}
-		items = append(items, id)
+		items = append(items, i)
 	}
 	if err := rows.Close(); err != nil {
 		return nil, err
@@ -117,17 +177,27 @@ func (q *Queries) FindChatBranches(ctx context.Context, chatID string) ([]string
 
 const findChatLog = `-- name: FindChatLog :many
 SELECT
+    id,
     ACTION,
-    meta
+    version,
+    actor,
+    meta,
+    created_at
 FROM
     chat_log
 WHERE
     chat_id = ?
+ORDER BY
+    id
 `
 
 type FindChatLogRow struct {
-	Action string
-	Meta   []byte
+	ID        string
+	Action    string
+	Version   int64
+	Actor     string
+	Meta      []byte
+	CreatedAt int64
 }
 
 func (q *Queries) FindChatLog(ctx context.Context, chatID string) ([]FindChatLogRow, error) {
@@ -139,7 +209,14 @@ func (q *Queries) FindChatLog(ctx context.Context, chatID string) ([]FindChatLog
 	var items []FindChatLogRow
 	for rows.Next() {
 		var i FindChatLogRow
-		if err := rows.Scan(&i.Action, &i.Meta); err != nil {
+		if err := rows.Scan(
+			&i.ID,
+			&i.Action,
+			&i.Version,
+			&i.Actor,
+			&i.Meta,
+			&i.CreatedAt,
+		); err != nil {
 			return nil, err
 		}
 		items = append(items, i)
@@ -156,14 +233,24 @@ func (q *Queries) FindChatLog(ctx context.Context, chatID string) ([]FindChatLog
 const findChatTitles = `-- name: FindChatTitles :many
 SELECT
     id,
-    title
+    title,
+    pinned_at,
+    archived_at
 FROM
     chat
+WHERE
+    deleted_at IS NULL
+ORDER BY
+    pinned_at IS NULL,
+    pinned_at DESC,
+    updated_at DESC
 `
 
 type FindChatTitlesRow struct {
-	ID    string
-	Title string
+	ID         string
+	Title      string
+	PinnedAt   sql.NullInt64
+	ArchivedAt sql.NullInt64
 }
 
 func (q *Queries) FindChatTitles(ctx context.Context) ([]FindChatTitlesRow, error) {
@@ -175,7 +262,12 @@ func (q *Queries) FindChatTitles(ctx context.Context) ([]FindChatTitlesRow, erro
 	var items []FindChatTitlesRow
 	for rows.Next() {
 		var i FindChatTitlesRow
-		if err := rows.Scan(&i.ID, &i.Title); err != nil {
+		if err := rows.Scan(
+			&i.ID,
+			&i.Title,
+			&i.PinnedAt,
+			&i.ArchivedAt,
+		); err != nil {
 			return nil, err
 		}
 		items = append(items, i)
@@ -223,42 +315,50 @@ func (q *Queries) FindTags(ctx context.Context, chatID string) ([]string, error)
 
 const saveChat = `-- name: SaveChat :exec
 INSERT INTO
-    chat (id, title, messages)
+    chat (id, title)
 VALUES
-    (?, ?, ?) ON conflict DO
+    (?, ?) ON conflict DO
 UPDATE
 SET
     title = excluded.title,
-    messages = excluded.messages,
     updated_at = unixepoch()
 `
 
 type SaveChatParams struct {
-	ID       string
-	Title    string
-	Messages []byte
+	ID    string
+	Title string
 }
 
 func (q *Queries) SaveChat(ctx context.Context, arg SaveChatParams) error {
-	_, err := q.db.ExecContext(ctx, saveChat, arg.ID, arg.Title, arg.Messages)
+	_, err := q.db.ExecContext(ctx, saveChat, arg.ID, arg.Title)
 	return err
 }
 
 const saveChatLog = `-- name: SaveChatLog :exec
 INSERT INTO
-    chat_log (chat_id, ACTION, meta)
+    chat_log (id, chat_id, ACTION, version, actor, meta)
 VALUES
-    (?, ?, ?)
+    (?, ?, ?, ?, ?, ?)
 `
 
 type SaveChatLogParams struct {
-	ChatID string
-	Action string
-	Meta   []byte
+	ID      string
+	ChatID  string
+	Action  string
+	Version int64
+	Actor   string
+	Meta    []byte
 }
 
 func (q *Queries) SaveChatLog(ctx context.Context, arg SaveChatLogParams) error {
-	_, err := q.db.ExecContext(ctx, saveChatLog, arg.ChatID, arg.Action, arg.Meta)
+	_, err := q.db.ExecContext(ctx, saveChatLog,
+		arg.ID,
+		arg.ChatID,
+		arg.Action,
+		arg.Version,
+		arg.Actor,
+		arg.Meta,
+	)
 	return err
 }
 
@@ -299,26 +399,23 @@ func (q *Queries) SaveMention(ctx context.Context, arg SaveMentionParams) error
 	return err
 }
 
-const saveOrUpdateChatBranchMessages = `-- name: SaveOrUpdateChatBranchMessages :exec
+const saveOrTouchChatBranch = `-- name: SaveOrTouchChatBranch :exec
 INSERT INTO
-    chat_branch (id, chat_id, messages)
+    chat_branch (id, chat_id)
 VALUES
-    (?, ?, ?) ON conflict (id, chat_id) DO
+    (?, ?) ON conflict (id, chat_id) DO
 UPDATE
 SET
-    id = excluded.id,
-    chat_id = excluded.chat_id,
-    messages = excluded.messages
+    updated_at = unixepoch()
 `
 
-type SaveOrUpdateChatBranchMessagesParams struct {
-	ID       string
-	ChatID   string
-	Messages []byte
+type SaveOrTouchChatBranchParams struct {
+	ID     string
+	ChatID string
 }
 
-func (q *Queries) SaveOrUpdateChatBranchMessages(ctx context.Context, arg SaveOrUpdateChatBranchMessagesParams) error {
-	_, err := q.db.ExecContext(ctx, saveOrUpdateChatBranchMessages, arg.ID, arg.ChatID, arg.Messages)
+func (q *Queries) SaveOrTouchChatBranch(ctx context.Context, arg SaveOrTouchChatBranchParams) error {
+	_, err := q.db.ExecContext(ctx, saveOrTouchChatBranch, arg.ID, arg.ChatID)
 	return err
 }
 
@@ -339,22 +436,66 @@ func (q *Queries) SaveTag(ctx context.Context, arg SaveTagParams) error {
 	return err
 }
 
-const updateChatMessages = `-- name: UpdateChatMessages :exec
+const touchChat = `-- name: TouchChat :exec
 UPDATE
     chat
 SET
-    messages = ?,
     updated_at = unixepoch()
 WHERE
     id = ?
 `
 
-type UpdateChatMessagesParams struct {
-	Messages []byte
-	ID       string
+func (q *Queries) TouchChat(ctx context.Context, id string) error {
+	_, err := q.db.ExecContext(ctx, touchChat, id)
+	return err
 }
 
-func (q *Queries) UpdateChatMessages(ctx context.Context, arg UpdateChatMessagesParams) error {
-	_, err := q.db.ExecContext(ctx, updateChatMessages, arg.Messages, arg.ID)
-	return err
+const updateChatBranchVersion = `-- name: UpdateChatBranchVersion :execrows
+UPDATE
+    chat_branch
+SET
+    version = version + 1,
+    updated_at = unixepoch()
+WHERE
+    chat_id = ?
+    AND id = ?
+    AND version = ?
+`
+
+type UpdateChatBranchVersionParams struct {
+	ChatID  string
+	ID      string
+	Version int64
+}
+
+func (q *Queries) UpdateChatBranchVersion(ctx context.Context, arg UpdateChatBranchVersionParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, updateChatBranchVersion, arg.ChatID, arg.ID, arg.Version)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const updateChatVersion = `-- name: UpdateChatVersion :execrows
+UPDATE
+    chat
+SET
+    version = version + 1,
+    updated_at = unixepoch()
+WHERE
+    id = ?
+    AND version = ?
+`
+
+type UpdateChatVersionParams struct {
+	ID      string
+	Version int64
+}
+
+func (q *Queries) UpdateChatVersion(ctx context.Context, arg UpdateChatVersionParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, updateChatVersion, arg.ID, arg.Version)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
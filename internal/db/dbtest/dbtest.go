@@ -0,0 +1,109 @@
+// Package dbtest provides databases for tests of the code running queries
+package dbtest
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	_ "modernc.org/sqlite"
+
+	"shellshift/internal/db"
+)
+
+// Opens an in-memory SQLite database with the schema of the user databases.
+// It has the single connection, so statements which escape the running
+// transaction block instead of silently passing
+func New(t testing.TB) (*db.Queries, *sql.DB) {
+	t.Helper()
+	conn, err := sql.Open("sqlite", ":memory:?_pragma=foreign_keys(1)")
+	if err != nil {
+		t.Fatalf("failed to open database with %s", err)
+	}
+	conn.SetMaxOpenConns(1)
+	t.Cleanup(func() { conn.Close() })
+
+	// Schema is found next to the sources, so tests run from any package
+	_, file, _, _ := runtime.Caller(0)
+	schema, err := os.ReadFile(filepath.Join(filepath.Dir(file), "../../../sql/migrations/schema.sql"))
+	if err != nil {
+		t.Fatalf("failed to read sql schema with %s", err)
+	}
+	for _, ddl := range strings.Split(string(schema), ";") {
+		if strings.TrimSpace(ddl) == "" {
+			continue
+		}
+		if _, err := conn.Exec(ddl); err != nil {
+			t.Fatalf("failed to apply schema with %s", err)
+		}
+	}
+	return db.New(conn), conn
+}
+
+// Makes statements of the kind ("INSERT", "UPDATE" or "DELETE") on the table
+// fail, so the statements before them have to be rolled back. Returned func
+// makes them succeed again
+func FailOn(t testing.TB, conn *sql.DB, kind, table string) (restore func()) {
+	t.Helper()
+	trigger := "fail_" + strings.ToLower(kind) + "_" + table
+	_, err := conn.Exec("CREATE TRIGGER " + trigger + " BEFORE " + kind + " ON " + table +
+		" BEGIN SELECT RAISE(ABORT, 'injected failure'); END")
+	if err != nil {
+		t.Fatalf("failed to create failing trigger with %s", err)
+	}
+	return func() {
+		t.Helper()
+		if _, err := conn.Exec("DROP TRIGGER " + trigger); err != nil {
+			t.Fatalf("failed to drop failing trigger with %s", err)
+		}
+	}
+}
+
+// Returns rows of every table as text, so the whole database state can be
+// compared before & after the statements
+func Dump(t testing.TB, conn *sql.DB) map[string][]string {
+	t.Helper()
+	tables, err := conn.Query("SELECT name FROM sqlite_master WHERE type = 'table'")
+	if err != nil {
+		t.Fatalf("failed to list tables with %s", err)
+	}
+	var names []string
+	for tables.Next() {
+		var name string
+		if err := tables.Scan(&name); err != nil {
+			t.Fatalf("failed to scan table name with %s", err)
+		}
+		names = append(names, name)
+	}
+	if err := tables.Close(); err != nil {
+		t.Fatalf("failed to list tables with %s", err)
+	}
+
+	dump := make(map[string][]string)
+	for _, name := range names {
+		rows, err := conn.Query("SELECT * FROM " + name + " ORDER BY rowid")
+		if err != nil {
+			t.Fatalf("failed to read %s with %s", name, err)
+		}
+		cols, _ := rows.Columns()
+		for rows.Next() {
+			vals := make([]any, len(cols))
+			ptrs := make([]any, len(cols))
+			for i := range vals {
+				ptrs[i] = &vals[i]
+			}
+			if err := rows.Scan(ptrs...); err != nil {
+				t.Fatalf("failed to scan %s with %s", name, err)
+			}
+			dump[name] = append(dump[name], fmt.Sprint(vals...))
+		}
+		if err := rows.Close(); err != nil {
+			t.Fatalf("failed to read %s with %s", name, err)
+		}
+	}
+	return dump
+}
@@ -2,9 +2,11 @@ package db
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	_ "embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -132,10 +134,7 @@ func (f *Factory) Get(id string) (*Queries, error) {
 		}
 		q := New(conn)
 		slog.Info("db retrieved from API")
-		// Update cache
-		f.l.Lock()
-		f.cache[id] = q
-		f.l.Unlock()
+		f.register(id, q)
 		return q, nil
 
 	case http.StatusNotFound:
@@ -208,15 +207,105 @@ func (f *Factory) Get(id string) (*Queries, error) {
 
 			q := New(conn)
 			slog.Info("new db was created")
-			// Update cache
-			f.l.Lock()
-			f.cache[id] = q
-			f.l.Unlock()
+			f.register(id, q)
 			return q, nil
 		}
 	}
 }
 
+// Stores the owner, so the database is matched to the user by LoadAll after
+// restarts, and updates cache
+func (f *Factory) register(id string, q *Queries) {
+	if err := q.SaveDBOwner(context.Background(), id); err != nil {
+		slog.Error("failed to save db owner", "with", err)
+	}
+	f.l.Lock()
+	f.cache[id] = q
+	f.l.Unlock()
+}
+
+// Opens databases of all users of the app, so the background work of the
+// users who haven't come back since the restart isn't left behind. Databases
+// are matched to their users by the owner, the ones which weren't accessed
+// since owners are stored are skipped
+func (f *Factory) LoadAll(ctx context.Context) error {
+	listURL := fmt.Sprintf("%s/v1/organizations/%s/databases?%s", f.apiHost, f.orgSlug, url.Values{"group": {f.group}}.Encode())
+	req, err := http.NewRequestWithContext(ctx, "GET", listURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to init db list request with %w", err)
+	}
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", f.authToken))
+	resp, err := f.c.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send list request with %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body with %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response status code %d: %s", resp.StatusCode, body)
+	}
+	var list listDbsResp
+	if err := json.Unmarshal(body, &list); err != nil {
+		return fmt.Errorf("failed to deserialize list response with %w", err)
+	}
+
+	var errs []error
+	var loaded int
+	for _, info := range list.Databases {
+		if !strings.HasPrefix(info.Name, f.appName+"-") {
+			continue
+		}
+		token, err := f.createDbToken(info.Name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to create db token with %w", err))
+			continue
+		}
+		conn, err := f.initConnection(info.Hostname, token)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to connect to %s with %w", info.Name, err))
+			continue
+		}
+		q := New(conn)
+		id, err := q.FindDBOwner(ctx)
+		if errors.Is(err, sql.ErrNoRows) {
+			slog.Warn("db without owner was skipped", "dbName", info.Name)
+			conn.Close()
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to find owner of %s with %w", info.Name, err))
+			conn.Close()
+			continue
+		}
+
+		// User could come back meanwhile
+		f.l.Lock()
+		if _, ok := f.cache[id]; ok {
+			conn.Close()
+		} else {
+			f.cache[id] = q
+			loaded++
+		}
+		f.l.Unlock()
+	}
+	slog.Info("user dbs were loaded", "amount", loaded)
+	return errors.Join(errs...)
+}
+
+// Returns queries of every database accessed or loaded since the server start
+func (f *Factory) All() map[string]*Queries {
+	f.l.RLock()
+	defer f.l.RUnlock()
+	all := make(map[string]*Queries, len(f.cache))
+	for id, q := range f.cache {
+		all[id] = q
+	}
+	return all
+}
+
 func (f *Factory) initConnection(hostname, token string) (*sql.DB, error) {
 	url := fmt.Sprintf("libsql://%s?authToken=%s", hostname, token)
 	return sql.Open("libsql", url)
@@ -274,6 +363,13 @@ type retrieveDbResp struct {
 	} `json:"database"`
 }
 
+type listDbsResp struct {
+	Databases []struct {
+		Name     string
+		Hostname string
+	} `json:"databases"`
+}
+
 type tursoTokenResp struct {
 	JWT string `json:"jwt"`
 }
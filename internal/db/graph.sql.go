@@ -12,10 +12,15 @@ import (
 
 const findChatMentions = `-- name: FindChatMentions :many
 SELECT
-    target_id,
-    source_id
+    m.target_id,
+    m.source_id
 FROM
-    mention
+    mention m
+    JOIN chat s ON m.source_id = s.id
+    JOIN chat t ON m.target_id = t.id
+WHERE
+    s.deleted_at IS NULL
+    AND t.deleted_at IS NULL
 `
 
 type FindChatMentionsRow struct {
@@ -51,17 +56,23 @@ SELECT
     c.id,
     c.title,
     c.updated_at,
+    c.pinned_at,
+    c.archived_at,
     t.name
 FROM
     chat_tag t
     RIGHT JOIN chat c ON t.chat_id = c.id
+WHERE
+    c.deleted_at IS NULL
 `
 
 type FindChatTagsRow struct {
-	ID        string
-	Title     string
-	UpdatedAt int64
-	Name      sql.NullString
+	ID         string
+	Title      string
+	UpdatedAt  int64
+	PinnedAt   sql.NullInt64
+	ArchivedAt sql.NullInt64
+	Name       sql.NullString
 }
 
 func (q *Queries) FindChatTags(ctx context.Context) ([]FindChatTagsRow, error) {
@@ -77,6 +88,8 @@ func (q *Queries) FindChatTags(ctx context.Context) ([]FindChatTagsRow, error) {
 			&i.ID,
 			&i.Title,
 			&i.UpdatedAt,
+			&i.PinnedAt,
+			&i.ArchivedAt,
 			&i.Name,
 		); err != nil {
 			return nil, err
@@ -4,26 +4,42 @@
 
 package db
 
+import (
+	"database/sql"
+)
+
 type Chat struct {
-	ID        string
-	Title     string
-	Messages  []byte
-	CreatedAt int64
-	UpdatedAt int64
+	ID         string
+	Title      string
+	CreatedAt  int64
+	UpdatedAt  int64
+	DeletedAt  sql.NullInt64
+	PinnedAt   sql.NullInt64
+	ArchivedAt sql.NullInt64
+	Version    int64
 }
 
 type ChatBranch struct {
-	ID        string
-	ChatID    string
-	Messages  []byte
-	CreatedAt int64
-	UpdatedAt int64
+	ID          string
+	ChatID      string
+	CreatedAt   int64
+	UpdatedAt   int64
+	DeletedAt   sql.NullInt64
+	Name        string
+	Description string
+	AbandonedAt sql.NullInt64
+	Origin      int64
+	Version     int64
 }
 
 type ChatLog struct {
-	ChatID string
-	Action string
-	Meta   []byte
+	ID        string
+	ChatID    string
+	Action    string
+	Version   int64
+	Actor     string
+	Meta      []byte
+	CreatedAt int64
 }
 
 type ChatTag struct {
@@ -31,11 +47,82 @@ type ChatTag struct {
 	Name   string
 }
 
+type Comment struct {
+	ID         string
+	ChatID     string
+	BranchID   string
+	MessageIdx int64
+	Text       string
+	Resolved   bool
+	CreatedAt  int64
+	UpdatedAt  int64
+}
+
+type DbOwner struct {
+	UserID string
+}
+
+type IdempotencyKey struct {
+	ChatID    string
+	BranchID  string
+	Key       string
+	Redirect  string
+	Body      []byte
+	CreatedAt int64
+}
+
+type Job struct {
+	ID          string
+	Kind        string
+	ChatID      string
+	BranchID    string
+	Payload     []byte
+	Status      string
+	Attempts    int64
+	MaxAttempts int64
+	RunAt       int64
+	LockedUntil int64
+	Error       string
+	CreatedAt   int64
+	UpdatedAt   int64
+}
+
 type Mention struct {
 	SourceID string
 	TargetID string
 }
 
+type Message struct {
+	ID           string
+	ChatID       string
+	BranchID     string
+	Position     int64
+	Role         string
+	Content      string
+	Model        string
+	InputTokens  int64
+	OutputTokens int64
+	SquashedFrom string
+	Incomplete   bool
+	JobPayload   []byte
+	CreatedAt    int64
+	UpdatedAt    int64
+}
+
 type SchemaMigration struct {
 	ID string
 }
+
+type Stream struct {
+	ID         string
+	Generation string
+	Done       bool
+	UpdatedAt  int64
+}
+
+type StreamChunk struct {
+	StreamID   string
+	Generation string
+	Position   int64
+	Text       string
+}
@@ -0,0 +1,31 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// Runs fn with queries bound to a new transaction, which is committed when
+// fn succeeds and rolled back otherwise
+func (q *Queries) Tx(ctx context.Context, fn func(q *Queries) error) error {
+	conn, ok := q.db.(*sql.DB)
+	if !ok {
+		return errors.New("queries are already bound to a transaction")
+	}
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction with %w", err)
+	}
+	if err := fn(q.WithTx(tx)); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return errors.Join(err, fmt.Errorf("failed to roll back transaction with %w", rbErr))
+		}
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction with %w", err)
+	}
+	return nil
+}
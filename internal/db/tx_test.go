@@ -0,0 +1,104 @@
+package db_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"shellshift/internal/db"
+	"shellshift/internal/db/dbtest"
+)
+
+func TestTx(t *testing.T) {
+	errFn := errors.New("fn failed")
+	saveChat := func(ctx context.Context, q *db.Queries) error {
+		return q.SaveChat(ctx, db.SaveChatParams{ID: "chat", Title: "Title"})
+	}
+	saveLog := func(ctx context.Context, q *db.Queries) error {
+		return q.SaveChatLog(ctx, db.SaveChatLogParams{
+			ID:      "log",
+			ChatID:  "chat",
+			Action:  "chat-created",
+			Version: 1,
+		})
+	}
+
+	tests := []struct {
+		name string
+		// Table which can't be inserted into
+		failOn string
+		fn     func(ctx context.Context, q *db.Queries) error
+		// Error which Tx should return, nil for the commit
+		wantErr   error
+		wantSaved bool
+	}{
+		{
+			name: "commits when fn succeeds",
+			fn: func(ctx context.Context, q *db.Queries) error {
+				if err := saveChat(ctx, q); err != nil {
+					return err
+				}
+				return saveLog(ctx, q)
+			},
+			wantSaved: true,
+		},
+		{
+			name: "rolls back when fn fails",
+			fn: func(ctx context.Context, q *db.Queries) error {
+				if err := saveChat(ctx, q); err != nil {
+					return err
+				}
+				return errFn
+			},
+			wantErr: errFn,
+		},
+		{
+			name:   "rolls back when a later statement fails",
+			failOn: "chat_log",
+			fn: func(ctx context.Context, q *db.Queries) error {
+				if err := saveChat(ctx, q); err != nil {
+					return err
+				}
+				return saveLog(ctx, q)
+			},
+		},
+		{
+			name: "rejects nested transaction",
+			fn: func(ctx context.Context, q *db.Queries) error {
+				if err := saveChat(ctx, q); err != nil {
+					return err
+				}
+				return q.Tx(ctx, func(q *db.Queries) error { return nil })
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			q, conn := dbtest.New(t)
+			if tt.failOn != "" {
+				dbtest.FailOn(t, conn, "INSERT", tt.failOn)
+			}
+
+			err := q.Tx(ctx, func(q *db.Queries) error { return tt.fn(ctx, q) })
+			switch {
+			case tt.wantSaved && err != nil:
+				t.Fatalf("Tx() failed with %s", err)
+			case !tt.wantSaved && err == nil:
+				t.Fatal("Tx() succeeded, want error")
+			case tt.wantErr != nil && !errors.Is(err, tt.wantErr):
+				t.Fatalf("Tx() error = %v, want %v", err, tt.wantErr)
+			}
+
+			_, err = q.FindChat(ctx, "chat")
+			saved := err == nil
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				t.Fatalf("failed to find chat with %s", err)
+			}
+			if saved != tt.wantSaved {
+				t.Errorf("chat saved = %t, want %t", saved, tt.wantSaved)
+			}
+		})
+	}
+}
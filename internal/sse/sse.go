@@ -1,19 +1,92 @@
 package sse
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// Interval of comments which keep idle connections open through proxies
+const HeartbeatInterval = 15 * time.Second
+
 type Event struct {
+	// Optional, should grow with every event of the stream
+	ID   int64
 	Type string
 	Data string
 }
 
-func Send(w http.ResponseWriter, e Event) {
+// Writes events of a single stream
+type Writer struct {
+	w  http.ResponseWriter
+	rc *http.ResponseController
+	// ID of the last sent event, or the one reported by the reconnected client
+	LastID int64
+}
+
+// Starts event stream, LastID is taken from the Last-Event-ID header of
+// the reconnected client
+func NewWriter(w http.ResponseWriter, r *http.Request) *Writer {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	s := &Writer{w: w, rc: http.NewResponseController(w)}
+	if id, err := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64); err == nil && id > 0 {
+		s.LastID = id
+	}
+	return s
+}
+
+// Whether the client has already received some events of the stream
+func (s *Writer) Resumed() bool {
+	return s.LastID > 0
+}
+
+func (s *Writer) Send(e Event) error {
+	if e.ID != 0 && e.ID <= s.LastID {
+		return fmt.Errorf("event id %d doesn't follow %d", e.ID, s.LastID)
+	}
+
+	var b strings.Builder
+	if e.ID != 0 {
+		fmt.Fprintf(&b, "id: %d\n", e.ID)
+	}
 	if e.Type != "" {
-		fmt.Fprintf(w, "event: %s\n", e.Type)
+		fmt.Fprintf(&b, "event: %s\n", e.Type)
+	}
+	// Every line of multiline data needs its own field
+	for _, line := range strings.Split(e.Data, "\n") {
+		fmt.Fprintf(&b, "data: %s\n", line)
+	}
+	b.WriteString("\n")
+
+	if err := s.write(b.String()); err != nil {
+		return err
+	}
+	if e.ID != 0 {
+		s.LastID = e.ID
+	}
+	return nil
+}
+
+// Sends comment which is ignored by clients
+func (s *Writer) Heartbeat() error {
+	return s.write(": heartbeat\n\n")
+}
+
+func (s *Writer) write(msg string) error {
+	if _, err := s.w.Write([]byte(msg)); err != nil {
+		return fmt.Errorf("failed to write event with %w", err)
+	}
+	if err := s.rc.Flush(); err != nil {
+		if errors.Is(err, http.ErrNotSupported) {
+			return errors.New("response doesn't support streaming")
+		}
+		return fmt.Errorf("failed to flush event with %w", err)
 	}
-	fmt.Fprintf(w, "data: %s\n\n", e.Data)
-	w.(http.Flusher).Flush()
+	return nil
 }
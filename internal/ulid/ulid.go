@@ -0,0 +1,84 @@
+// Lexicographically sortable IDs, see https://github.com/ulid/spec
+package ulid
+
+import (
+	"crypto/rand"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+const alphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// Length of the encoded ID
+const Length = 26
+
+var (
+	l        sync.Mutex
+	lastMs   uint64
+	lastRand [10]byte
+)
+
+// Generates ID which is larger than all IDs generated before in the process
+func New() string {
+	ms := uint64(time.Now().UnixMilli())
+
+	l.Lock()
+	defer l.Unlock()
+
+	// Randomness is incremented within the same millisecond to keep order
+	if ms <= lastMs {
+		ms = lastMs
+		for i := len(lastRand) - 1; i >= 0; i-- {
+			lastRand[i]++
+			if lastRand[i] != 0 {
+				break
+			}
+		}
+	} else {
+		lastMs = ms
+		_, _ = rand.Read(lastRand[:])
+	}
+
+	var b [16]byte
+	for i := 5; i >= 0; i-- {
+		b[i] = byte(ms)
+		ms >>= 8
+	}
+	copy(b[6:], lastRand[:])
+	return encode(b)
+}
+
+// Encodes 128 bits as 26 base32 chars, the first char holds only 3 bits
+func encode(b [16]byte) string {
+	var sb strings.Builder
+	sb.Grow(Length)
+	var acc uint64
+	bits := 2 // 130 encoded bits - 128 data bits
+	for _, v := range b {
+		acc = acc<<8 | uint64(v)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			sb.WriteByte(alphabet[(acc>>bits)&31])
+		}
+	}
+	return sb.String()
+}
+
+// Returns time encoded in the ID
+func Time(id string) (time.Time, error) {
+	if len(id) != Length {
+		return time.Time{}, errors.New("invalid ulid length")
+	}
+	var ms uint64
+	for _, c := range id[:10] {
+		i := strings.IndexRune(alphabet, c)
+		if i == -1 {
+			return time.Time{}, errors.New("invalid ulid char")
+		}
+		ms = ms<<5 | uint64(i)
+	}
+	return time.UnixMilli(int64(ms)), nil
+}
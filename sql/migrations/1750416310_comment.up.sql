@@ -0,0 +1,11 @@
+CREATE TABLE comment (
+    id TEXT PRIMARY KEY,
+    chat_id TEXT NOT NULL,
+    branch_id TEXT NOT NULL,
+    message_idx INTEGER NOT NULL,
+    text TEXT NOT NULL,
+    resolved BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at INTEGER NOT NULL DEFAULT (unixepoch()),
+    updated_at INTEGER NOT NULL DEFAULT (unixepoch()),
+    FOREIGN KEY (chat_id) REFERENCES chat (id) ON DELETE CASCADE
+);
@@ -0,0 +1,3 @@
+ALTER TABLE chat_branch DROP COLUMN deleted_at;
+
+ALTER TABLE chat DROP COLUMN deleted_at;
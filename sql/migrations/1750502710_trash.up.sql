@@ -0,0 +1,3 @@
+ALTER TABLE chat ADD COLUMN deleted_at INTEGER;
+
+ALTER TABLE chat_branch ADD COLUMN deleted_at INTEGER;
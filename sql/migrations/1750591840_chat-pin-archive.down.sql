@@ -0,0 +1,3 @@
+ALTER TABLE chat DROP COLUMN archived_at;
+
+ALTER TABLE chat DROP COLUMN pinned_at;
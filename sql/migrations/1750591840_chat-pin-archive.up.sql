@@ -0,0 +1,3 @@
+ALTER TABLE chat ADD COLUMN pinned_at INTEGER;
+
+ALTER TABLE chat ADD COLUMN archived_at INTEGER;
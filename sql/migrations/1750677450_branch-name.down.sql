@@ -0,0 +1,5 @@
+ALTER TABLE chat_branch DROP COLUMN abandoned_at;
+
+ALTER TABLE chat_branch DROP COLUMN description;
+
+ALTER TABLE chat_branch DROP COLUMN name;
@@ -0,0 +1,5 @@
+ALTER TABLE chat_branch ADD COLUMN name TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE chat_branch ADD COLUMN description TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE chat_branch ADD COLUMN abandoned_at INTEGER;
@@ -0,0 +1 @@
+ALTER TABLE chat_branch DROP COLUMN origin;
@@ -0,0 +1,24 @@
+ALTER TABLE chat_branch ADD COLUMN origin INTEGER NOT NULL DEFAULT -1;
+
+-- Origins were only logged before, so the last creation or rebase of the
+-- branch is taken
+UPDATE
+    chat_branch
+SET
+    origin = coalesce(
+        (
+            SELECT
+                json_extract(CAST(meta AS TEXT), '$.OriginMessageIdx')
+            FROM
+                chat_log
+            WHERE
+                chat_log.chat_id = chat_branch.chat_id
+                AND ACTION IN ('branch-created', 'branch-rebased')
+                AND json_extract(CAST(meta AS TEXT), '$.BranchID') = chat_branch.id
+            ORDER BY
+                chat_log.rowid DESC
+            LIMIT
+                1
+        ),
+        -1
+    );
@@ -0,0 +1,23 @@
+ALTER TABLE chat_log RENAME TO chat_log_new;
+
+CREATE TABLE chat_log (
+    chat_id text NOT NULL,
+    ACTION text NOT NULL,
+    meta blob,
+    FOREIGN KEY (chat_id) REFERENCES chat(id) ON DELETE CASCADE
+);
+
+INSERT INTO
+    chat_log (chat_id, ACTION, meta)
+SELECT
+    chat_id,
+    ACTION,
+    meta
+FROM
+    chat_log_new
+WHERE
+    ACTION NOT LIKE 'messages-%'
+ORDER BY
+    id;
+
+DROP TABLE chat_log_new;
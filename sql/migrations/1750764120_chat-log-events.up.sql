@@ -0,0 +1,53 @@
+ALTER TABLE chat_log RENAME TO chat_log_old;
+
+CREATE TABLE chat_log (
+    id TEXT PRIMARY KEY,
+    chat_id TEXT NOT NULL,
+    ACTION TEXT NOT NULL,
+    version INTEGER NOT NULL DEFAULT 1,
+    actor TEXT NOT NULL DEFAULT '',
+    meta BLOB,
+    created_at INTEGER NOT NULL DEFAULT (unixepoch()),
+    FOREIGN KEY (chat_id) REFERENCES chat(id) ON DELETE CASCADE
+);
+
+CREATE INDEX chat_log_chat_id_idx ON chat_log (chat_id, id);
+
+-- Old entries have no time, ids keep their order & sort before generated ones
+INSERT INTO
+    chat_log (id, chat_id, ACTION, meta, created_at)
+SELECT
+    printf('0000000000%016d', rowid),
+    chat_id,
+    ACTION,
+    meta,
+    0
+FROM
+    chat_log_old;
+
+DROP TABLE chat_log_old;
+
+-- Snapshots of the current messages, so replay has something to start from
+INSERT INTO
+    chat_log (id, chat_id, ACTION, actor, meta, created_at)
+SELECT
+    printf('0000000001%016d', rowid),
+    id,
+    'messages-replaced',
+    'system',
+    json_object('BranchID', '00000000-0000-0000-0000-000000000000', 'Messages', json(CAST(messages AS TEXT))),
+    0
+FROM
+    chat;
+
+INSERT INTO
+    chat_log (id, chat_id, ACTION, actor, meta, created_at)
+SELECT
+    printf('0000000002%016d', rowid),
+    chat_id,
+    'messages-replaced',
+    'system',
+    json_object('BranchID', id, 'Messages', json(CAST(messages AS TEXT))),
+    0
+FROM
+    chat_branch;
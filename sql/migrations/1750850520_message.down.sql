@@ -0,0 +1,96 @@
+ALTER TABLE chat ADD COLUMN messages BLOB NOT NULL DEFAULT '[]';
+
+ALTER TABLE chat_branch ADD COLUMN messages BLOB NOT NULL DEFAULT '[]';
+
+-- Every field of the message is kept, zero time is omitted like in Go
+UPDATE
+    chat
+SET
+    messages = (
+        SELECT
+            json_group_array(
+                json(
+                    CASE
+                        WHEN created_at > 0 THEN json_set(
+                            msg,
+                            '$.CreatedAt',
+                            strftime('%Y-%m-%dT%H:%M:%SZ', created_at, 'unixepoch')
+                        )
+                        ELSE msg
+                    END
+                )
+            )
+        FROM
+            (
+                SELECT
+                    json_object(
+                        'Text',
+                        content,
+                        'Role',
+                        role,
+                        'Model',
+                        model,
+                        'InputTokens',
+                        input_tokens,
+                        'OutputTokens',
+                        output_tokens,
+                        'SquashedFrom',
+                        squashed_from
+                    ) AS msg,
+                    created_at
+                FROM
+                    message
+                WHERE
+                    chat_id = chat.id
+                    AND branch_id = '00000000-0000-0000-0000-000000000000'
+                ORDER BY
+                    position
+            )
+    );
+
+UPDATE
+    chat_branch
+SET
+    messages = (
+        SELECT
+            json_group_array(
+                json(
+                    CASE
+                        WHEN created_at > 0 THEN json_set(
+                            msg,
+                            '$.CreatedAt',
+                            strftime('%Y-%m-%dT%H:%M:%SZ', created_at, 'unixepoch')
+                        )
+                        ELSE msg
+                    END
+                )
+            )
+        FROM
+            (
+                SELECT
+                    json_object(
+                        'Text',
+                        content,
+                        'Role',
+                        role,
+                        'Model',
+                        model,
+                        'InputTokens',
+                        input_tokens,
+                        'OutputTokens',
+                        output_tokens,
+                        'SquashedFrom',
+                        squashed_from
+                    ) AS msg,
+                    created_at
+                FROM
+                    message
+                WHERE
+                    chat_id = chat_branch.chat_id
+                    AND branch_id = chat_branch.id
+                ORDER BY
+                    position
+            )
+    );
+
+DROP TABLE message;
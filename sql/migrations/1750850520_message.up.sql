@@ -0,0 +1,82 @@
+CREATE TABLE message (
+    id TEXT PRIMARY KEY,
+    chat_id TEXT NOT NULL,
+    branch_id TEXT NOT NULL,
+    position INTEGER NOT NULL,
+    role TEXT NOT NULL,
+    content TEXT NOT NULL,
+    model TEXT NOT NULL DEFAULT '',
+    input_tokens INTEGER NOT NULL DEFAULT 0,
+    output_tokens INTEGER NOT NULL DEFAULT 0,
+    squashed_from TEXT NOT NULL DEFAULT '',
+    created_at INTEGER NOT NULL DEFAULT (unixepoch()),
+    updated_at INTEGER NOT NULL DEFAULT (unixepoch()),
+    FOREIGN KEY (chat_id) REFERENCES chat (id) ON DELETE CASCADE,
+    UNIQUE (chat_id, branch_id, position)
+);
+
+-- Main messages are stored under the nil branch id, like comments. Old
+-- messages have no ULIDs, ids keep their order & sort before generated ones
+INSERT INTO
+    message (
+        id,
+        chat_id,
+        branch_id,
+        position,
+        role,
+        content,
+        model,
+        input_tokens,
+        output_tokens,
+        squashed_from,
+        created_at
+    )
+SELECT
+    printf('0000000000%016d', row_number() OVER (ORDER BY c.rowid, m.key)),
+    c.id,
+    '00000000-0000-0000-0000-000000000000',
+    m.key,
+    json_extract(m.value, '$.Role'),
+    json_extract(m.value, '$.Text'),
+    coalesce(json_extract(m.value, '$.Model'), ''),
+    coalesce(json_extract(m.value, '$.InputTokens'), 0),
+    coalesce(json_extract(m.value, '$.OutputTokens'), 0),
+    coalesce(json_extract(m.value, '$.SquashedFrom'), ''),
+    coalesce(unixepoch(json_extract(m.value, '$.CreatedAt')), 0)
+FROM
+    chat c,
+    json_each(CAST(c.messages AS TEXT)) m;
+
+INSERT INTO
+    message (
+        id,
+        chat_id,
+        branch_id,
+        position,
+        role,
+        content,
+        model,
+        input_tokens,
+        output_tokens,
+        squashed_from,
+        created_at
+    )
+SELECT
+    printf('0000000001%016d', row_number() OVER (ORDER BY b.rowid, m.key)),
+    b.chat_id,
+    b.id,
+    m.key,
+    json_extract(m.value, '$.Role'),
+    json_extract(m.value, '$.Text'),
+    coalesce(json_extract(m.value, '$.Model'), ''),
+    coalesce(json_extract(m.value, '$.InputTokens'), 0),
+    coalesce(json_extract(m.value, '$.OutputTokens'), 0),
+    coalesce(json_extract(m.value, '$.SquashedFrom'), ''),
+    coalesce(unixepoch(json_extract(m.value, '$.CreatedAt')), 0)
+FROM
+    chat_branch b,
+    json_each(CAST(b.messages AS TEXT)) m;
+
+ALTER TABLE chat DROP COLUMN messages;
+
+ALTER TABLE chat_branch DROP COLUMN messages;
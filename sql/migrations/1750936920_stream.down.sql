@@ -0,0 +1,3 @@
+DROP TABLE stream_chunk;
+
+DROP TABLE stream;
@@ -0,0 +1,17 @@
+CREATE TABLE stream (
+    id TEXT PRIMARY KEY,
+    generation TEXT NOT NULL DEFAULT '',
+    done BOOLEAN NOT NULL DEFAULT FALSE,
+    updated_at INTEGER NOT NULL DEFAULT (unixepoch())
+);
+
+CREATE INDEX stream_updated_at_idx ON stream (updated_at);
+
+CREATE TABLE stream_chunk (
+    stream_id TEXT NOT NULL,
+    generation TEXT NOT NULL,
+    -- Byte offset of the chunk in the stream text
+    position INTEGER NOT NULL,
+    text TEXT NOT NULL,
+    PRIMARY KEY (stream_id, generation, position)
+);
@@ -0,0 +1,5 @@
+DROP INDEX job_chat_id_idx;
+
+DROP INDEX job_status_run_at_idx;
+
+DROP TABLE job;
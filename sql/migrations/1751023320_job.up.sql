@@ -0,0 +1,20 @@
+CREATE TABLE job (
+    id TEXT PRIMARY KEY,
+    kind TEXT NOT NULL,
+    chat_id TEXT NOT NULL,
+    branch_id TEXT NOT NULL DEFAULT '',
+    payload BLOB NOT NULL DEFAULT '{}',
+    status TEXT NOT NULL DEFAULT 'queued',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    max_attempts INTEGER NOT NULL,
+    run_at INTEGER NOT NULL DEFAULT (unixepoch()),
+    locked_until INTEGER NOT NULL DEFAULT 0,
+    error TEXT NOT NULL DEFAULT '',
+    created_at INTEGER NOT NULL DEFAULT (unixepoch()),
+    updated_at INTEGER NOT NULL DEFAULT (unixepoch()),
+    FOREIGN KEY (chat_id) REFERENCES chat (id) ON DELETE CASCADE
+);
+
+CREATE INDEX job_status_run_at_idx ON job (status, run_at);
+
+CREATE INDEX job_chat_id_idx ON job (chat_id, branch_id, kind, id);
@@ -0,0 +1,3 @@
+CREATE TABLE db_owner (
+    user_id TEXT PRIMARY KEY
+);
@@ -0,0 +1,2 @@
+ALTER TABLE message DROP COLUMN job_payload;
+ALTER TABLE message DROP COLUMN incomplete;
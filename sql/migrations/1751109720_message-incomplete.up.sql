@@ -0,0 +1,3 @@
+ALTER TABLE message ADD COLUMN incomplete BOOLEAN NOT NULL DEFAULT FALSE;
+-- Payload of the job which generated the incomplete answer
+ALTER TABLE message ADD COLUMN job_payload BLOB NOT NULL DEFAULT '';
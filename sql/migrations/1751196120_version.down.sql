@@ -0,0 +1,3 @@
+ALTER TABLE chat_branch DROP COLUMN version;
+
+ALTER TABLE chat DROP COLUMN version;
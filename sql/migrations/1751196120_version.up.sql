@@ -0,0 +1,3 @@
+ALTER TABLE chat ADD COLUMN version INTEGER NOT NULL DEFAULT 0;
+
+ALTER TABLE chat_branch ADD COLUMN version INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,12 @@
+CREATE TABLE idempotency_key (
+    chat_id TEXT NOT NULL,
+    branch_id TEXT NOT NULL,
+    key TEXT NOT NULL,
+    redirect TEXT NOT NULL DEFAULT '',
+    body BLOB NOT NULL DEFAULT '',
+    created_at INTEGER NOT NULL DEFAULT (unixepoch()),
+    PRIMARY KEY (chat_id, branch_id, key),
+    FOREIGN KEY (chat_id) REFERENCES chat (id) ON DELETE CASCADE
+);
+
+CREATE INDEX idempotency_key_created_at_idx ON idempotency_key (created_at);
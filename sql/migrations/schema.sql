@@ -1,9 +1,12 @@
 CREATE TABLE chat (
     id TEXT PRIMARY KEY,
     title TEXT NOT NULL,
-    messages BLOB NOT NULL,
     created_at INTEGER NOT NULL DEFAULT (unixepoch ()),
-    updated_at INTEGER NOT NULL DEFAULT (unixepoch ())
+    updated_at INTEGER NOT NULL DEFAULT (unixepoch ()),
+    deleted_at INTEGER,
+    pinned_at INTEGER,
+    archived_at INTEGER,
+    version INTEGER NOT NULL DEFAULT 0
 );
 
 CREATE TABLE chat_tag (
@@ -24,16 +27,115 @@ CREATE TABLE mention (
 CREATE TABLE chat_branch (
     id TEXT NOT NULL,
     chat_id TEXT NOT NULL,
-    messages BLOB NOT NULL,
     created_at INTEGER NOT NULL DEFAULT (unixepoch()),
     updated_at INTEGER NOT NULL DEFAULT (unixepoch()),
+    deleted_at INTEGER,
+    name TEXT NOT NULL DEFAULT '',
+    description TEXT NOT NULL DEFAULT '',
+    abandoned_at INTEGER,
+    origin INTEGER NOT NULL DEFAULT -1,
+    version INTEGER NOT NULL DEFAULT 0,
     FOREIGN KEY (chat_id) REFERENCES chat(id) ON DELETE CASCADE,
     PRIMARY KEY(id, chat_id)
 );
 
 CREATE TABLE chat_log (
-    chat_id text NOT NULL,
-    ACTION text NOT NULL,
-    meta blob,
+    id TEXT PRIMARY KEY,
+    chat_id TEXT NOT NULL,
+    ACTION TEXT NOT NULL,
+    version INTEGER NOT NULL DEFAULT 1,
+    actor TEXT NOT NULL DEFAULT '',
+    meta BLOB,
+    created_at INTEGER NOT NULL DEFAULT (unixepoch()),
     FOREIGN KEY (chat_id) REFERENCES chat(id) ON DELETE CASCADE
 );
+
+CREATE INDEX chat_log_chat_id_idx ON chat_log (chat_id, id);
+
+CREATE TABLE comment (
+    id TEXT PRIMARY KEY,
+    chat_id TEXT NOT NULL,
+    branch_id TEXT NOT NULL,
+    message_idx INTEGER NOT NULL,
+    text TEXT NOT NULL,
+    resolved BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at INTEGER NOT NULL DEFAULT (unixepoch()),
+    updated_at INTEGER NOT NULL DEFAULT (unixepoch()),
+    FOREIGN KEY (chat_id) REFERENCES chat (id) ON DELETE CASCADE
+);
+
+CREATE TABLE message (
+    id TEXT PRIMARY KEY,
+    chat_id TEXT NOT NULL,
+    branch_id TEXT NOT NULL,
+    position INTEGER NOT NULL,
+    role TEXT NOT NULL,
+    content TEXT NOT NULL,
+    model TEXT NOT NULL DEFAULT '',
+    input_tokens INTEGER NOT NULL DEFAULT 0,
+    output_tokens INTEGER NOT NULL DEFAULT 0,
+    squashed_from TEXT NOT NULL DEFAULT '',
+    incomplete BOOLEAN NOT NULL DEFAULT FALSE,
+    -- Payload of the job which generated the incomplete answer
+    job_payload BLOB NOT NULL DEFAULT '',
+    created_at INTEGER NOT NULL DEFAULT (unixepoch()),
+    updated_at INTEGER NOT NULL DEFAULT (unixepoch()),
+    FOREIGN KEY (chat_id) REFERENCES chat (id) ON DELETE CASCADE,
+    UNIQUE (chat_id, branch_id, position)
+);
+
+CREATE TABLE stream (
+    id TEXT PRIMARY KEY,
+    generation TEXT NOT NULL DEFAULT '',
+    done BOOLEAN NOT NULL DEFAULT FALSE,
+    updated_at INTEGER NOT NULL DEFAULT (unixepoch())
+);
+
+CREATE INDEX stream_updated_at_idx ON stream (updated_at);
+
+CREATE TABLE stream_chunk (
+    stream_id TEXT NOT NULL,
+    generation TEXT NOT NULL,
+    -- Byte offset of the chunk in the stream text
+    position INTEGER NOT NULL,
+    text TEXT NOT NULL,
+    PRIMARY KEY (stream_id, generation, position)
+);
+
+CREATE TABLE job (
+    id TEXT PRIMARY KEY,
+    kind TEXT NOT NULL,
+    chat_id TEXT NOT NULL,
+    branch_id TEXT NOT NULL DEFAULT '',
+    payload BLOB NOT NULL DEFAULT '{}',
+    status TEXT NOT NULL DEFAULT 'queued',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    max_attempts INTEGER NOT NULL,
+    run_at INTEGER NOT NULL DEFAULT (unixepoch()),
+    locked_until INTEGER NOT NULL DEFAULT 0,
+    error TEXT NOT NULL DEFAULT '',
+    created_at INTEGER NOT NULL DEFAULT (unixepoch()),
+    updated_at INTEGER NOT NULL DEFAULT (unixepoch()),
+    FOREIGN KEY (chat_id) REFERENCES chat (id) ON DELETE CASCADE
+);
+
+CREATE INDEX job_status_run_at_idx ON job (status, run_at);
+
+CREATE INDEX job_chat_id_idx ON job (chat_id, branch_id, kind, id);
+
+CREATE TABLE db_owner (
+    user_id TEXT PRIMARY KEY
+);
+
+CREATE TABLE idempotency_key (
+    chat_id TEXT NOT NULL,
+    branch_id TEXT NOT NULL,
+    key TEXT NOT NULL,
+    redirect TEXT NOT NULL DEFAULT '',
+    body BLOB NOT NULL DEFAULT '',
+    created_at INTEGER NOT NULL DEFAULT (unixepoch()),
+    PRIMARY KEY (chat_id, branch_id, key),
+    FOREIGN KEY (chat_id) REFERENCES chat (id) ON DELETE CASCADE
+);
+
+CREATE INDEX idempotency_key_created_at_idx ON idempotency_key (created_at);
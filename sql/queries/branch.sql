@@ -0,0 +1,54 @@
+-- name: UpdateChatBranchName :exec
+UPDATE
+    chat_branch
+SET
+    name = ?
+WHERE
+    chat_id = ?
+    AND id = ?;
+
+-- name: UpdateChatBranchDescription :exec
+UPDATE
+    chat_branch
+SET
+    description = ?
+WHERE
+    chat_id = ?
+    AND id = ?;
+
+-- name: AbandonChatBranch :exec
+UPDATE
+    chat_branch
+SET
+    abandoned_at = unixepoch()
+WHERE
+    chat_id = ?
+    AND id = ?;
+
+-- name: ReopenChatBranch :exec
+UPDATE
+    chat_branch
+SET
+    abandoned_at = NULL,
+    updated_at = unixepoch()
+WHERE
+    chat_id = ?
+    AND id = ?;
+
+-- name: UpdateChatBranchOrigin :exec
+UPDATE
+    chat_branch
+SET
+    origin = ?
+WHERE
+    chat_id = ?
+    AND id = ?;
+
+-- name: ShiftChatBranchOrigins :exec
+UPDATE
+    chat_branch
+SET
+    origin = max(sqlc.arg(message_idx) - 1, origin - sqlc.arg(amount))
+WHERE
+    chat_id = sqlc.arg(chat_id)
+    AND origin >= sqlc.arg(message_idx);
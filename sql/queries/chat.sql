@@ -1,7 +1,10 @@
 -- name: FindChat :one
 SELECT
     title,
-    messages
+    deleted_at,
+    pinned_at,
+    archived_at,
+    version
 FROM
     chat
 WHERE
@@ -10,19 +13,26 @@ WHERE
 -- name: FindChatTitles :many
 SELECT
     id,
-    title
+    title,
+    pinned_at,
+    archived_at
 FROM
-    chat;
+    chat
+WHERE
+    deleted_at IS NULL
+ORDER BY
+    pinned_at IS NULL,
+    pinned_at DESC,
+    updated_at DESC;
 
 -- name: SaveChat :exec
 INSERT INTO
-    chat (id, title, messages)
+    chat (id, title)
 VALUES
-    (?, ?, ?) ON conflict DO
+    (?, ?) ON conflict DO
 UPDATE
 SET
     title = excluded.title,
-    messages = excluded.messages,
     updated_at = unixepoch();
 
 -- name: SaveTag :exec
@@ -55,11 +65,10 @@ SET
 WHERE
     id = ?;
 
--- name: UpdateChatMessages :exec
+-- name: TouchChat :exec
 UPDATE
     chat
 SET
-    messages = ?,
     updated_at = unixepoch()
 WHERE
     id = ?;
@@ -74,47 +83,85 @@ VALUES
 DELETE FROM
     chat
 WHERE
-    id = ?;
+    id = ?
+    AND deleted_at IS NOT NULL;
 
 -- name: FindChatBranch :one
 SELECT
-    messages
+    updated_at,
+    deleted_at,
+    name,
+    description,
+    abandoned_at,
+    origin,
+    version
 FROM
     chat_branch
 WHERE
     chat_id = ?
     AND id = ?;
 
--- name: SaveOrUpdateChatBranchMessages :exec
+-- name: SaveOrTouchChatBranch :exec
 INSERT INTO
-    chat_branch (id, chat_id, messages)
+    chat_branch (id, chat_id)
 VALUES
-    (?, ?, ?) ON conflict (id, chat_id) DO
+    (?, ?) ON conflict (id, chat_id) DO
 UPDATE
 SET
-    id = excluded.id,
-    chat_id = excluded.chat_id,
-    messages = excluded.messages;
+    updated_at = unixepoch();
 
 -- name: FindChatBranches :many
 SELECT
-    id
+    id,
+    updated_at,
+    name,
+    description,
+    abandoned_at,
+    origin
 FROM
     chat_branch
 WHERE
-    chat_id = ?;
+    chat_id = ?
+    AND deleted_at IS NULL;
 
 -- name: SaveChatLog :exec
 INSERT INTO
-    chat_log (chat_id, ACTION, meta)
+    chat_log (id, chat_id, ACTION, version, actor, meta)
 VALUES
-    (?, ?, ?);
+    (?, ?, ?, ?, ?, ?);
 
 -- name: FindChatLog :many
 SELECT
+    id,
     ACTION,
-    meta
+    version,
+    actor,
+    meta,
+    created_at
 FROM
     chat_log
 WHERE
-    chat_id = ?;
+    chat_id = ?
+ORDER BY
+    id;
+
+-- name: UpdateChatVersion :execrows
+UPDATE
+    chat
+SET
+    version = version + 1,
+    updated_at = unixepoch()
+WHERE
+    id = ?
+    AND version = ?;
+
+-- name: UpdateChatBranchVersion :execrows
+UPDATE
+    chat_branch
+SET
+    version = version + 1,
+    updated_at = unixepoch()
+WHERE
+    chat_id = ?
+    AND id = ?
+    AND version = ?;
@@ -0,0 +1,64 @@
+-- name: MoveChatBranch :exec
+UPDATE
+    chat_branch
+SET
+    chat_id = sqlc.arg(target_id)
+WHERE
+    chat_id = sqlc.arg(source_id)
+    AND id = sqlc.arg(id);
+
+-- name: MoveComment :exec
+UPDATE
+    comment
+SET
+    chat_id = ?,
+    message_idx = ?
+WHERE
+    id = ?;
+
+-- name: MoveBranchComments :exec
+UPDATE
+    comment
+SET
+    chat_id = sqlc.arg(target_id)
+WHERE
+    chat_id = sqlc.arg(source_id)
+    AND branch_id = sqlc.arg(branch_id);
+
+-- name: MoveBranchMessages :exec
+UPDATE
+    message
+SET
+    chat_id = sqlc.arg(target_id)
+WHERE
+    chat_id = sqlc.arg(source_id)
+    AND branch_id = sqlc.arg(branch_id);
+
+-- name: CopyChatTags :exec
+INSERT
+    OR IGNORE INTO chat_tag (chat_id, name)
+SELECT
+    sqlc.arg(target_id),
+    name
+FROM
+    chat_tag
+WHERE
+    chat_id = sqlc.arg(source_id);
+
+-- name: MoveMentionSources :exec
+UPDATE
+    OR IGNORE mention
+SET
+    source_id = sqlc.arg(target_id)
+WHERE
+    source_id = sqlc.arg(source_id)
+    AND target_id <> sqlc.arg(target_id);
+
+-- name: MoveMentionTargets :exec
+UPDATE
+    OR IGNORE mention
+SET
+    target_id = sqlc.arg(target_id)
+WHERE
+    target_id = sqlc.arg(source_id)
+    AND source_id <> sqlc.arg(target_id);
@@ -0,0 +1,112 @@
+-- name: SaveComment :exec
+INSERT INTO
+    comment (id, chat_id, branch_id, message_idx, text)
+VALUES
+    (?, ?, ?, ?, ?);
+
+-- name: FindComment :one
+SELECT
+    id,
+    branch_id,
+    message_idx,
+    text,
+    resolved
+FROM
+    comment
+WHERE
+    chat_id = ?
+    AND id = ?;
+
+-- name: FindComments :many
+SELECT
+    id,
+    message_idx,
+    text,
+    resolved
+FROM
+    comment
+WHERE
+    chat_id = ?
+    AND branch_id = ?
+ORDER BY
+    message_idx,
+    created_at;
+
+-- name: FindUnresolvedComments :many
+SELECT
+    id,
+    message_idx,
+    text,
+    resolved
+FROM
+    comment
+WHERE
+    chat_id = ?
+    AND branch_id = ?
+    AND resolved = FALSE
+ORDER BY
+    message_idx,
+    created_at;
+
+-- name: UpdateCommentText :exec
+UPDATE
+    comment
+SET
+    text = ?,
+    updated_at = unixepoch()
+WHERE
+    chat_id = ?
+    AND id = ?;
+
+-- name: UpdateCommentResolved :exec
+UPDATE
+    comment
+SET
+    resolved = ?,
+    updated_at = unixepoch()
+WHERE
+    chat_id = ?
+    AND id = ?;
+
+-- name: DeleteComment :exec
+DELETE FROM
+    comment
+WHERE
+    chat_id = ?
+    AND id = ?;
+
+-- name: DeleteBranchComments :exec
+DELETE FROM
+    comment
+WHERE
+    chat_id = sqlc.arg(chat_id)
+    AND branch_id = sqlc.arg(branch_id)
+    AND EXISTS (
+        SELECT
+            1
+        FROM
+            chat_branch
+        WHERE
+            chat_id = sqlc.arg(chat_id)
+            AND id = sqlc.arg(branch_id)
+            AND deleted_at IS NOT NULL
+    );
+
+-- name: DeleteRangeComments :exec
+DELETE FROM
+    comment
+WHERE
+    chat_id = ?
+    AND branch_id = ?
+    AND message_idx >= sqlc.arg(start)
+    AND message_idx < sqlc.arg(end);
+
+-- name: ShiftComments :exec
+UPDATE
+    comment
+SET
+    message_idx = message_idx - sqlc.arg(amount)
+WHERE
+    chat_id = sqlc.arg(chat_id)
+    AND branch_id = sqlc.arg(branch_id)
+    AND message_idx >= sqlc.arg(message_idx);
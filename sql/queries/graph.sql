@@ -3,14 +3,23 @@ SELECT
     c.id,
     c.title,
     c.updated_at,
+    c.pinned_at,
+    c.archived_at,
     t.name
 FROM
     chat_tag t
-    RIGHT JOIN chat c ON t.chat_id = c.id;
+    RIGHT JOIN chat c ON t.chat_id = c.id
+WHERE
+    c.deleted_at IS NULL;
 
 -- name: FindChatMentions :many
 SELECT
-    target_id,
-    source_id
+    m.target_id,
+    m.source_id
 FROM
-    mention;
+    mention m
+    JOIN chat s ON m.source_id = s.id
+    JOIN chat t ON m.target_id = t.id
+WHERE
+    s.deleted_at IS NULL
+    AND t.deleted_at IS NULL;
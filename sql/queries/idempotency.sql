@@ -0,0 +1,23 @@
+-- name: FindIdempotencyKey :one
+SELECT
+    redirect,
+    body
+FROM
+    idempotency_key
+WHERE
+    chat_id = ?
+    AND branch_id = ?
+    AND key = ?
+    AND created_at >= ?;
+
+-- name: SaveIdempotencyKey :exec
+INSERT INTO
+    idempotency_key (chat_id, branch_id, key, redirect, body)
+VALUES
+    (?, ?, ?, ?, ?);
+
+-- name: DeleteExpiredIdempotencyKeys :exec
+DELETE FROM
+    idempotency_key
+WHERE
+    created_at < ?;
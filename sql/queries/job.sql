@@ -0,0 +1,119 @@
+-- name: SaveJob :exec
+INSERT INTO
+    job (id, kind, chat_id, branch_id, payload, max_attempts)
+VALUES
+    (?, ?, ?, ?, ?, ?);
+
+-- name: ClaimJob :one
+UPDATE
+    job
+SET
+    status = 'running',
+    attempts = attempts + 1,
+    locked_until = sqlc.arg(locked_until),
+    updated_at = unixepoch()
+WHERE
+    id = (
+        SELECT
+            id
+        FROM
+            job
+        WHERE
+            (
+                status = 'queued'
+                AND run_at <= unixepoch()
+            )
+            OR (
+                status = 'running'
+                AND locked_until < unixepoch()
+            )
+        ORDER BY
+            id
+        LIMIT
+            1
+    ) RETURNING id,
+    kind,
+    chat_id,
+    branch_id,
+    payload,
+    attempts,
+    max_attempts;
+
+-- name: ExtendJobLock :exec
+UPDATE
+    job
+SET
+    locked_until = ?
+WHERE
+    id = ?
+    AND status = 'running';
+
+-- name: RetryJob :exec
+UPDATE
+    job
+SET
+    status = 'queued',
+    run_at = ?,
+    error = ?,
+    locked_until = 0,
+    updated_at = unixepoch()
+WHERE
+    id = ?;
+
+-- name: FailJob :exec
+UPDATE
+    job
+SET
+    status = 'failed',
+    error = ?,
+    locked_until = 0,
+    updated_at = unixepoch()
+WHERE
+    id = ?;
+
+-- name: RequeueFailedJob :exec
+UPDATE
+    job
+SET
+    status = 'queued',
+    attempts = 0,
+    run_at = unixepoch(),
+    error = '',
+    updated_at = unixepoch()
+WHERE
+    id = ?
+    AND status = 'failed';
+
+-- name: DeleteJob :exec
+DELETE FROM
+    job
+WHERE
+    id = ?;
+
+-- name: DeleteFailedJobs :exec
+DELETE FROM
+    job
+WHERE
+    chat_id = ?
+    AND branch_id = ?
+    AND kind = ?
+    AND status = 'failed';
+
+-- name: FindLatestJob :one
+SELECT
+    id,
+    status,
+    attempts,
+    max_attempts,
+    run_at,
+    error
+FROM
+    job
+WHERE
+    chat_id = ?
+    AND branch_id = ?
+    AND kind = ?
+ORDER BY
+    id DESC
+LIMIT
+    1;
@@ -0,0 +1,98 @@
+-- name: FindMessages :many
+SELECT
+    id,
+    role,
+    content,
+    model,
+    input_tokens,
+    output_tokens,
+    squashed_from,
+    incomplete,
+    job_payload,
+    created_at
+FROM
+    message
+WHERE
+    chat_id = ?
+    AND branch_id = ?
+ORDER BY
+    position;
+
+-- name: FindMessagesBefore :many
+SELECT
+    id,
+    position,
+    role,
+    content,
+    model,
+    input_tokens,
+    output_tokens,
+    squashed_from,
+    incomplete,
+    created_at
+FROM
+    message
+WHERE
+    chat_id = ?
+    AND branch_id = ?
+    AND position < ?
+ORDER BY
+    position DESC
+LIMIT
+    ?;
+
+-- name: SaveMessage :exec
+INSERT INTO
+    message (
+        id,
+        chat_id,
+        branch_id,
+        position,
+        role,
+        content,
+        model,
+        input_tokens,
+        output_tokens,
+        squashed_from,
+        incomplete,
+        job_payload,
+        created_at
+    )
+VALUES
+    (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
+
+-- name: DeleteMessagesFrom :exec
+DELETE FROM
+    message
+WHERE
+    chat_id = ?
+    AND branch_id = ?
+    AND position >= ?;
+
+-- name: DeleteBranchMessages :exec
+DELETE FROM
+    message
+WHERE
+    chat_id = sqlc.arg(chat_id)
+    AND branch_id = sqlc.arg(branch_id)
+    AND EXISTS (
+        SELECT
+            1
+        FROM
+            chat_branch
+        WHERE
+            chat_id = sqlc.arg(chat_id)
+            AND id = sqlc.arg(branch_id)
+            AND deleted_at IS NOT NULL
+    );
+
+-- name: UpdateMessageContent :exec
+UPDATE
+    message
+SET
+    content = ?,
+    updated_at = unixepoch()
+WHERE
+    chat_id = ?
+    AND branch_id = ?
+    AND position = ?;
@@ -0,0 +1,13 @@
+-- name: SaveDBOwner :exec
+INSERT
+    OR IGNORE INTO db_owner (user_id)
+VALUES
+    (?);
+
+-- name: FindDBOwner :one
+SELECT
+    user_id
+FROM
+    db_owner
+LIMIT
+    1;
@@ -0,0 +1,31 @@
+-- name: PinChat :exec
+UPDATE
+    chat
+SET
+    pinned_at = unixepoch()
+WHERE
+    id = ?;
+
+-- name: UnpinChat :exec
+UPDATE
+    chat
+SET
+    pinned_at = NULL
+WHERE
+    id = ?;
+
+-- name: ArchiveChat :exec
+UPDATE
+    chat
+SET
+    archived_at = unixepoch()
+WHERE
+    id = ?;
+
+-- name: UnarchiveChat :exec
+UPDATE
+    chat
+SET
+    archived_at = NULL
+WHERE
+    id = ?;
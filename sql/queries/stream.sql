@@ -0,0 +1,82 @@
+-- name: OpenStream :exec
+INSERT INTO
+    stream (id, generation)
+VALUES
+    (?, ?) ON conflict DO
+UPDATE
+SET
+    generation = excluded.generation,
+    done = FALSE,
+    updated_at = unixepoch();
+
+-- name: TouchStream :execrows
+UPDATE
+    stream
+SET
+    updated_at = unixepoch()
+WHERE
+    id = ?
+    AND generation = ?;
+
+-- name: SaveStreamChunk :exec
+INSERT INTO
+    stream_chunk (stream_id, generation, position, text)
+VALUES
+    (?, ?, ?, ?);
+
+-- name: CloseStream :exec
+UPDATE
+    stream
+SET
+    done = TRUE,
+    updated_at = unixepoch()
+WHERE
+    id = ?
+    AND generation = ?;
+
+-- name: FindStream :one
+SELECT
+    generation,
+    done,
+    updated_at
+FROM
+    stream
+WHERE
+    id = ?;
+
+-- name: FindStreamChunks :many
+SELECT
+    text
+FROM
+    stream_chunk
+WHERE
+    stream_id = ?
+    AND generation = ?
+    AND position >= ?
+ORDER BY
+    position;
+
+-- name: DeleteStreamChunks :exec
+DELETE FROM
+    stream_chunk
+WHERE
+    stream_id = ?;
+
+-- name: DeleteExpiredStreamChunks :exec
+DELETE FROM
+    stream_chunk
+WHERE
+    stream_id IN (
+        SELECT
+            id
+        FROM
+            stream
+        WHERE
+            updated_at < ?
+    );
+
+-- name: DeleteExpiredStreams :exec
+DELETE FROM
+    stream
+WHERE
+    updated_at < ?;
@@ -0,0 +1,110 @@
+-- name: TrashChat :exec
+UPDATE
+    chat
+SET
+    deleted_at = unixepoch()
+WHERE
+    id = ?
+    AND deleted_at IS NULL;
+
+-- name: RestoreChat :exec
+UPDATE
+    chat
+SET
+    deleted_at = NULL,
+    updated_at = unixepoch()
+WHERE
+    id = ?;
+
+-- name: FindTrashedChats :many
+SELECT
+    id,
+    title,
+    deleted_at
+FROM
+    chat
+WHERE
+    deleted_at IS NOT NULL
+ORDER BY
+    deleted_at DESC;
+
+-- name: TrashChatBranch :exec
+UPDATE
+    chat_branch
+SET
+    deleted_at = unixepoch()
+WHERE
+    chat_id = ?
+    AND id = ?
+    AND deleted_at IS NULL;
+
+-- name: RestoreChatBranch :exec
+UPDATE
+    chat_branch
+SET
+    deleted_at = NULL
+WHERE
+    chat_id = ?
+    AND id = ?;
+
+-- name: DeleteChatBranch :exec
+DELETE FROM
+    chat_branch
+WHERE
+    chat_id = ?
+    AND id = ?
+    AND deleted_at IS NOT NULL;
+
+-- name: FindTrashedChatBranches :many
+SELECT
+    b.id,
+    b.chat_id,
+    b.name,
+    c.title,
+    b.deleted_at
+FROM
+    chat_branch b
+    JOIN chat c ON b.chat_id = c.id
+WHERE
+    b.deleted_at IS NOT NULL
+    AND c.deleted_at IS NULL
+ORDER BY
+    b.deleted_at DESC;
+
+-- name: PurgeChats :exec
+DELETE FROM
+    chat
+WHERE
+    deleted_at < ?;
+
+-- name: PurgeChatBranchComments :exec
+DELETE FROM
+    comment
+WHERE
+    branch_id IN (
+        SELECT
+            id
+        FROM
+            chat_branch
+        WHERE
+            deleted_at < ?
+    );
+
+-- name: PurgeChatBranchMessages :exec
+DELETE FROM
+    message
+WHERE
+    branch_id IN (
+        SELECT
+            id
+        FROM
+            chat_branch
+        WHERE
+            deleted_at < ?
+    );
+
+-- name: PurgeChatBranches :exec
+DELETE FROM
+    chat_branch
+WHERE
+    deleted_at < ?;
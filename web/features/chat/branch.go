@@ -0,0 +1,468 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+	"github.com/google/uuid"
+
+	"shellshift/internal/db"
+)
+
+// Lifecycle state of the branch derived from the chat log
+type BranchState string
+
+const (
+	// Branch is being worked on and has nothing to merge yet
+	BranchActive BranchState = "active"
+	// Branch has messages which were never merged
+	BranchUnmerged BranchState = "unmerged"
+	// Some messages were merged, but branch has grown since the last merge
+	BranchPartiallyMerged BranchState = "partially-merged"
+	// Tip of the branch is merged into main
+	BranchMerged BranchState = "merged"
+	// Branch was abandoned explicitly or wasn't touched for a long time
+	// while having unmerged messages
+	BranchAbandoned BranchState = "abandoned"
+)
+
+// Unmerged branches without updates for this period are considered abandoned
+const abandonAfter = 14 * 24 * time.Hour
+
+// Branch has something to merge into main. Explicitly abandoned branches
+// should be reopened first, while the ones abandoned by inactivity can be
+// merged as is
+func (b Branch) Mergeable() bool {
+	switch b.State {
+	case BranchUnmerged, BranchPartiallyMerged:
+		return true
+	case BranchAbandoned:
+		return !b.Abandoned
+	}
+	return false
+}
+
+// Computes branch state by replaying its merges from the chat log
+func branchState(log []LogEntry, b Branch) BranchState {
+	if b.Abandoned {
+		return BranchAbandoned
+	}
+	// Branch is merged when all of its messages are, the default selection
+	// skips messages in the middle
+	merged := false
+	mergedIdxs := make(map[int]bool)
+	for _, m := range findMerges(log) {
+		if m.Reverted || m.BranchID != b.ID.String() {
+			continue
+		}
+		merged = true
+		// Entries written before merged indexes were logged are
+		// considered to merge the whole branch
+		if len(m.MessageIdxs) == 0 {
+			for idx := range b.Messages {
+				mergedIdxs[idx] = true
+			}
+			continue
+		}
+		for _, idx := range m.MessageIdxs {
+			mergedIdxs[idx] = true
+		}
+	}
+	allMerged := true
+	for idx := range b.Messages {
+		allMerged = allMerged && mergedIdxs[idx]
+	}
+
+	var state BranchState
+	switch {
+	case merged && allMerged:
+		return BranchMerged
+	case merged:
+		state = BranchPartiallyMerged
+	case len(b.Messages) < 2:
+		return BranchActive
+	default:
+		state = BranchUnmerged
+	}
+	if time.Since(b.UpdatedAt) > abandonAfter {
+		return BranchAbandoned
+	}
+	return state
+}
+
+// Finds all not trashed branches of the chat in the order of their creation
+func findChatBranches(ctx context.Context, q *db.Queries, chatID uuid.UUID, log []LogEntry) ([]Branch, error) {
+	rows, err := q.FindChatBranches(ctx, chatID.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to find chat branches with %w", err)
+	}
+
+	// Order branches by the log
+	var order []string
+	for _, entry := range log {
+		if created, ok := entry.Meta.(LogBranchCreated); ok {
+			order = append(order, created.BranchID)
+		}
+	}
+	slices.SortStableFunc(rows, func(a, b db.FindChatBranchesRow) int {
+		return slices.Index(order, a.ID) - slices.Index(order, b.ID)
+	})
+
+	branches := make([]Branch, len(rows))
+	for i, row := range rows {
+		b := Branch{
+			UpdatedAt:   time.Unix(row.UpdatedAt, 0),
+			Name:        row.Name,
+			Description: row.Description,
+			Abandoned:   row.AbandonedAt.Valid,
+			Origin:      int(row.Origin),
+		}
+		b.ID, err = uuid.Parse(row.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse branch id with %w", err)
+		}
+		b.Messages, err = findMessages(ctx, q, chatID, b.ID)
+		if err != nil {
+			return nil, err
+		}
+		b.State = branchState(log, b)
+		branches[i] = b
+	}
+	return branches, nil
+}
+
+// Fills state of the branch from the chat log, which isn't loaded by every
+// branch read
+func loadBranchState(ctx context.Context, q *db.Queries, chatID uuid.UUID, b *Branch) error {
+	log, err := findChatLog(ctx, q, chatID)
+	if err != nil {
+		return err
+	}
+	b.State = branchState(log, *b)
+	return nil
+}
+
+// Index of the main message branch was based on at the end of the log,
+// replays creation, rebases & reverted merges
+func branchOrigin(log []LogEntry, branchID uuid.UUID) int {
+	origin := -1
+	for _, entry := range log {
+		switch m := entry.Meta.(type) {
+		case LogBranchCreated:
+			if m.BranchID == branchID.String() {
+				origin = m.OriginMessageIdx
+			}
+		case LogBranchRebased:
+			if m.BranchID == branchID.String() {
+				origin = m.OriginMessageIdx
+			}
+		case LogBranchMergeReverted:
+			// Removed main messages shift the origin back
+			if origin >= m.MessageIdx {
+				origin = max(m.MessageIdx-1, origin-m.Amount)
+			}
+		}
+	}
+	return origin
+}
+
+// Messages of the branch as they are seen by the model: main up to the
+// branch origin followed by the branch messages
+func branchHistory(chat Chat, b Branch) []Message {
+	if b.ID == mainBranchID {
+		return chat.Messages
+	}
+	origin := min(b.Origin, len(chat.Messages)-1)
+	return append(chat.Messages[:origin+1:origin+1], b.Messages...)
+}
+
+// Name shown to the user, falls back to the short form of ID
+func (b Branch) DisplayName() string {
+	if b.Name != "" {
+		return b.Name
+	}
+	return branchShortName(b.ID.String())
+}
+
+func branchShortName(id string) string {
+	return "branch-" + id[max(len(id)-4, 0):]
+}
+
+// Parses branch ID where empty value & "main" stand for main
+func parseBranchRef(raw string) (uuid.UUID, error) {
+	if raw == "" || raw == "main" {
+		return mainBranchID, nil
+	}
+	return uuid.Parse(raw)
+}
+
+// Branch shown in selects
+type branchOption struct {
+	ID   string
+	Name string
+}
+
+type generatedBranchName struct {
+	Name string `json:"name"`
+}
+
+func genBranchName(ctx context.Context, g *genkit.Genkit, msg string) (string, error) {
+	slog.Info("generating branch name")
+	prompt := genkit.LookupPrompt(g, "branch-name-generation")
+	if prompt == nil {
+		return "", fmt.Errorf("failed to find branch name generation prompt")
+	}
+	resp, err := prompt.Execute(ctx, ai.WithInput(map[string]any{"query": msg}))
+	if err != nil {
+		return "", err
+	}
+	var output generatedBranchName
+	if err := resp.Output(&output); err != nil {
+		return "", fmt.Errorf("failed to parse branch name output with %w", err)
+	}
+	return strings.TrimSpace(output.Name), nil
+}
+
+// Generates branch name from its first message and logs it
+func nameBranch(ctx context.Context, g *genkit.Genkit, q *db.Queries, chatID, branchID uuid.UUID, msg string) error {
+	name, err := genBranchName(ctx, g, msg)
+	if err != nil {
+		return fmt.Errorf("failed to generate branch name with %w", err)
+	}
+	if name == "" || len(name) > maxBranchNameLength {
+		slog.Warn("generated branch name was skipped", "name", name)
+		return nil
+	}
+	return inTx(ctx, q, func(ctx context.Context, q *db.Queries) error {
+		err := q.UpdateChatBranchName(ctx, db.UpdateChatBranchNameParams{
+			Name:   name,
+			ChatID: chatID.String(),
+			ID:     branchID.String(),
+		})
+		if err != nil {
+			slog.Error("failed to save branch name", "with", err)
+			return err
+		}
+		return saveChatLog(ctx, q, chatID, LogBranchRenamed{
+			BranchID:  branchID.String(),
+			Name:      name,
+			Generated: true,
+		})
+	})
+}
+
+const (
+	maxBranchNameLength        = 60
+	maxBranchDescriptionLength = 500
+)
+
+type branchInfoView struct {
+	ChatID      string
+	ID          string
+	Name        string
+	Description string
+	Abandoned   bool
+	Origin      int
+	// Amount of main messages added after the branch origin
+	Behind  int
+	BaseURI string
+}
+
+// Main length is the amount of main messages, which the branch may be behind
+func newBranchInfoView(chatID uuid.UUID, mainLen int, b Branch, baseURI string) branchInfoView {
+	return branchInfoView{
+		ChatID:      chatID.String(),
+		ID:          b.ID.String(),
+		Name:        b.DisplayName(),
+		Description: b.Description,
+		Abandoned:   b.Abandoned,
+		Origin:      b.Origin,
+		Behind:      max(mainLen-1-b.Origin, 0),
+		BaseURI:     baseURI,
+	}
+}
+
+func (h ChatHandler) putBranch(w http.ResponseWriter, r *http.Request) {
+	// Validate data
+	chatID, err := deserID(w, r)
+	var errs []error
+	if err != nil {
+		errs = append(errs, err)
+	}
+	// Branch param always exists because of routing
+	branchID, _, err := deserBranchID(w, r)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		errs = append(errs, fmt.Errorf("branch name can not be empty"))
+	}
+	if len(name) > maxBranchNameLength {
+		errs = append(errs, fmt.Errorf("branch name should not be larger than %d chars", maxBranchNameLength))
+	}
+	description := strings.TrimSpace(r.FormValue("description"))
+	if len(description) > maxBranchDescriptionLength {
+		errs = append(errs, fmt.Errorf("branch description should not be larger than %d chars", maxBranchDescriptionLength))
+	}
+	if len(errs) > 0 {
+		http.Error(w, errors.Join(errs...).Error(), http.StatusBadRequest)
+		return
+	}
+
+	q, err := h.getQueries(w, r)
+	if err != nil {
+		return
+	}
+
+	branch, err := findChatBranch(r.Context(), q, chatID, branchID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(branch.Messages) == 0 {
+		http.Error(w, "Branch doesn't exist", http.StatusNotFound)
+		return
+	}
+
+	// Update changed fields only, the fields & their log entries are saved
+	// together
+	err = inTx(r.Context(), q, func(ctx context.Context, q *db.Queries) error {
+		if name != branch.DisplayName() {
+			err := q.UpdateChatBranchName(ctx, db.UpdateChatBranchNameParams{
+				Name:   name,
+				ChatID: chatID.String(),
+				ID:     branchID.String(),
+			})
+			if err != nil {
+				slog.Error("failed to update branch name", "with", err)
+				return err
+			}
+			err = saveChatLog(ctx, q, chatID, LogBranchRenamed{
+				BranchID: branchID.String(),
+				Name:     name,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if description != branch.Description {
+			err := q.UpdateChatBranchDescription(ctx, db.UpdateChatBranchDescriptionParams{
+				Description: description,
+				ChatID:      chatID.String(),
+				ID:          branchID.String(),
+			})
+			if err != nil {
+				slog.Error("failed to update branch description", "with", err)
+				return err
+			}
+			return saveChatLog(ctx, q, chatID, LogBranchDescribed{
+				BranchID:    branchID.String(),
+				Description: description,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if name != branch.DisplayName() {
+		branch.Name = name
+	}
+	branch.Description = description
+
+	chat, err := findChat(r.Context(), q, chatID)
+	if err != nil {
+		slog.Error("failed to find chat", "err", err.Error())
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err := h.templates.Render(w, "branch-info", newBranchInfoView(chat.ID, len(chat.Messages), branch, h.baseURI)); err != nil {
+		slog.Error("failed to render branch info", "with", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (h ChatHandler) postBranchAbandon(w http.ResponseWriter, r *http.Request) {
+	h.setBranchAbandoned(w, r, true)
+}
+
+func (h ChatHandler) deleteBranchAbandon(w http.ResponseWriter, r *http.Request) {
+	h.setBranchAbandoned(w, r, false)
+}
+
+func (h ChatHandler) setBranchAbandoned(w http.ResponseWriter, r *http.Request, abandoned bool) {
+	// Validate data
+	chatID, err := deserID(w, r)
+	var errs []error
+	if err != nil {
+		errs = append(errs, err)
+	}
+	// Branch param always exists because of routing
+	branchID, _, err := deserBranchID(w, r)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		http.Error(w, errors.Join(errs...).Error(), http.StatusBadRequest)
+		return
+	}
+
+	q, err := h.getQueries(w, r)
+	if err != nil {
+		return
+	}
+
+	branch, err := findChatBranch(r.Context(), q, chatID, branchID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(branch.Messages) == 0 {
+		http.Error(w, "Branch doesn't exist", http.StatusNotFound)
+		return
+	}
+
+	// Update branch & log the action
+	err = inTx(r.Context(), q, func(ctx context.Context, q *db.Queries) error {
+		if abandoned {
+			err := q.AbandonChatBranch(ctx, db.AbandonChatBranchParams{
+				ChatID: chatID.String(),
+				ID:     branchID.String(),
+			})
+			if err != nil {
+				return err
+			}
+			return saveChatLog(ctx, q, chatID, LogBranchAbandoned{BranchID: branchID.String()})
+		}
+		err := q.ReopenChatBranch(ctx, db.ReopenChatBranchParams{
+			ChatID: chatID.String(),
+			ID:     branchID.String(),
+		})
+		if err != nil {
+			return err
+		}
+		return saveChatLog(ctx, q, chatID, LogBranchReopened{BranchID: branchID.String()})
+	})
+	if err != nil {
+		slog.Error("failed to update branch abandonment", "abandoned", abandoned, "with", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Abandoned branches are hidden, so user is sent back to main
+	if abandoned {
+		w.Header().Set("HX-Redirect", fmt.Sprintf("%s/%s", h.baseURI, chatID))
+		return
+	}
+	w.Header().Set("HX-Refresh", "true")
+}
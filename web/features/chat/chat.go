@@ -9,9 +9,10 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"math"
 	"net/http"
-	"slices"
 	"strings"
+	"time"
 
 	"github.com/firebase/genkit/go/genkit"
 	"github.com/firebase/genkit/go/plugins/googlegenai"
@@ -30,64 +31,138 @@ type ChatHandler struct {
 	g         *genkit.Genkit
 	msgChan   *textchan.TextChan
 	titleChan *textchan.TextChan
-	baseURI   string
-	graphURI  string
-	db        *db.Factory
+	// Wakes up the job runner to claim new jobs
+	jobWake        chan struct{}
+	branchLocks    *branchLocks
+	baseURI        string
+	graphURI       string
+	db             *db.Factory
+	trashRetention time.Duration
 }
 
-func InitMux(dbF *db.Factory, protector *auth.ProtectionMiddleware, baseURI, graphURI string) *http.ServeMux {
+// Model used for messages, stored with every generated message
+const defaultModel = "googleai/gemini-2.0-flash"
+
+// Streams are shared through the user database when the server runs in
+// multiple instances
+func InitMux(dbF *db.Factory, protector *auth.ProtectionMiddleware, baseURI, graphURI string, trashRetention time.Duration, sharedStreams bool) *http.ServeMux {
 	ctx := context.Background()
 	g, err := genkit.Init(ctx,
 		genkit.WithPlugins(&googlegenai.GoogleAI{}),
-		genkit.WithDefaultModel("googleai/gemini-2.0-flash"),
+		genkit.WithDefaultModel(defaultModel),
 	)
 	if err != nil {
 		panic(fmt.Sprintf("could not initialize Genkit: %v", err))
 	}
 
-	h := ChatHandler{
-		templates: templates.New("web/features/chat/views/*.html"),
-		g:         g,
-		msgChan:   textchan.New(),
-		titleChan: textchan.New(),
-		baseURI:   baseURI,
-		graphURI:  graphURI,
-		db:        dbF,
+	var broker textchan.Broker = textchan.NewMemoryBroker()
+	if sharedStreams {
+		broker = textchan.NewDBBroker(func(ctx context.Context) (*db.Queries, error) {
+			userID, ok := ctx.Value(auth.UserIDKey).(string)
+			if !ok || userID == "" {
+				return nil, errors.New("stream is used without user")
+			}
+			return dbF.Get(userID)
+		})
 	}
+
+	h := ChatHandler{
+		templates:      templates.New("web/features/chat/views/*.html"),
+		g:              g,
+		msgChan:        textchan.New("message", broker),
+		titleChan:      textchan.New("title", broker),
+		jobWake:        make(chan struct{}, 1),
+		branchLocks:    newBranchLocks(),
+		baseURI:        baseURI,
+		graphURI:       graphURI,
+		db:             dbF,
+		trashRetention: trashRetention,
+	}
+	go runTrashPurge(ctx, dbF, trashRetention)
+	go h.recoverJobs(ctx)
+	go h.runJobs(ctx)
+
 	m := http.NewServeMux()
 	m.HandleFunc("GET /", protector.Protect(h.getEmptyChat))
 	m.HandleFunc("GET /redirect", protector.Protect(h.redirect))
+	m.HandleFunc("GET /trash", protector.Protect(h.getTrash))
+	m.HandleFunc("GET /events", protector.Protect(h.getFeed))
 	m.HandleFunc("GET /{id}", protector.Protect(h.getChat))
 	m.HandleFunc("DELETE /{id}", protector.Protect(h.deleteChat))
+	m.HandleFunc("POST /{id}/restore", protector.Protect(h.postChatRestore))
+	m.HandleFunc("DELETE /{id}/trash", protector.Protect(h.deleteChatTrash))
+	m.HandleFunc("POST /{id}/pin", protector.Protect(h.postPin))
+	m.HandleFunc("DELETE /{id}/pin", protector.Protect(h.deletePin))
+	m.HandleFunc("POST /{id}/archive", protector.Protect(h.postArchive))
+	m.HandleFunc("DELETE /{id}/archive", protector.Protect(h.deleteArchive))
+	m.HandleFunc("GET /{id}/diff", protector.Protect(h.getDiff))
+	m.HandleFunc("POST /{id}/merge/{mergeId}/revert", protector.Protect(h.postMergeRevert))
+	m.HandleFunc("POST /{id}/split", protector.Protect(h.postSplit))
+	m.HandleFunc("POST /{id}/combine", protector.Protect(h.postCombine))
+	m.HandleFunc("POST /{id}/checkout", protector.Protect(h.postCheckout))
 	m.HandleFunc("GET /{id}/branch", protector.Protect(h.getBranches))
 	m.HandleFunc("GET /{id}/branch/{branchId}", protector.Protect(h.getChat))
+	m.HandleFunc("PUT /{id}/branch/{branchId}", protector.Protect(h.putBranch))
+	m.HandleFunc("DELETE /{id}/branch/{branchId}", protector.Protect(h.deleteBranch))
+	m.HandleFunc("POST /{id}/branch/{branchId}/abandon", protector.Protect(h.postBranchAbandon))
+	m.HandleFunc("DELETE /{id}/branch/{branchId}/abandon", protector.Protect(h.deleteBranchAbandon))
+	m.HandleFunc("POST /{id}/branch/{branchId}/restore", protector.Protect(h.postBranchRestore))
+	m.HandleFunc("DELETE /{id}/branch/{branchId}/trash", protector.Protect(h.deleteBranchTrash))
 	m.HandleFunc("POST /{id}/branch/{branchId}/message", protector.Protect(h.postUserMessage))
+	m.HandleFunc("GET /{id}/branch/{branchId}/messages", protector.Protect(h.getMessages))
 	m.HandleFunc("GET /{id}/branch/{branchId}/message/stream", protector.Protect(h.getMessageStream))
+	m.HandleFunc("POST /{id}/branch/{branchId}/message/continue", protector.Protect(h.postMessageContinue))
 	m.HandleFunc("GET /{id}/branch/{branchId}/merge-status", protector.Protect(h.getMergeStatus))
 	m.HandleFunc("GET /{id}/branch/{branchId}/merge", protector.Protect(h.getMerge))
 	m.HandleFunc("POST /{id}/branch/{branchId}/merge", protector.Protect(h.postMerge))
+	m.HandleFunc("POST /{id}/branch/{branchId}/merge/squash", protector.Protect(h.postSquashPreview))
+	m.HandleFunc("GET /{id}/branch/{branchId}/cherry-pick", protector.Protect(h.getCherryPick))
+	m.HandleFunc("POST /{id}/branch/{branchId}/rebase", protector.Protect(h.postRebase))
+	m.HandleFunc("POST /{id}/branch/{branchId}/cherry-pick", protector.Protect(h.postCherryPick))
+	m.HandleFunc("POST /{id}/branch/{branchId}/job/retry", protector.Protect(h.postJobRetry))
 	m.HandleFunc("GET /{id}/title", protector.Protect(h.getTitle))
 	m.HandleFunc("GET /{id}/tags", protector.Protect(h.getTags))
 	m.HandleFunc("POST /{id}/tags", protector.Protect(h.postTags))
 	m.HandleFunc("DELETE /{id}/tags", protector.Protect(h.deleteTags))
+	m.HandleFunc("POST /{id}/comment", protector.Protect(h.postComment))
+	m.HandleFunc("PUT /{id}/comment/{commentId}", protector.Protect(h.putComment))
+	m.HandleFunc("DELETE /{id}/comment/{commentId}", protector.Protect(h.deleteComment))
+	m.HandleFunc("POST /{id}/comment/{commentId}/resolve", protector.Protect(h.postCommentResolve))
+	m.HandleFunc("DELETE /{id}/comment/{commentId}/resolve", protector.Protect(h.deleteCommentResolve))
 	return m
 }
 
 type ChatRender struct {
-	ID       uuid.UUID
-	Title    string
-	Messages []HTMLMessage
+	ID    uuid.UUID
+	Title string
 }
 
 type ChatViewData struct {
-	Chat              ChatRender
-	Branch            Branch
+	Chat       ChatRender
+	Branch     Branch
+	BranchInfo branchInfoView
+	// Last page of the shown messages
+	Page              messagePage
 	ChatTitles        []db.FindChatTitlesRow
 	Keybinds          web.KeybindsTable
 	BaseURI           string
 	GraphURI          string
 	MessageGenerating bool
-	Empty             bool
+	// Set when the answer to the last message couldn't be generated
+	FailedJob *jobView
+	// Last message is a checkpointed answer which is no longer generated
+	Continuable bool
+	// Merge view is opened on load
+	OpenMerge bool
+	Empty     bool
+}
+
+// Branch which messages are shown, main for empty branches
+func (v ChatViewData) ShownBranchID() uuid.UUID {
+	if len(v.Branch.Messages) > 0 {
+		return v.Branch.ID
+	}
+	return mainBranchID
 }
 
 func (h ChatHandler) redirect(w http.ResponseWriter, r *http.Request) {
@@ -96,6 +171,11 @@ func (h ChatHandler) redirect(w http.ResponseWriter, r *http.Request) {
 
 // TODO: Add streaming message to new chat response
 func (h ChatHandler) getChat(w http.ResponseWriter, r *http.Request) {
+	// Past states are rendered read-only
+	if r.URL.Query().Get("at") != "" {
+		h.getChatAt(w, r)
+		return
+	}
 	id, err := deserID(w, r)
 	if err != nil {
 		slog.Error("failed to parse chat id", "with", err)
@@ -113,21 +193,44 @@ func (h ChatHandler) getChat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Only the last page of messages is loaded, older ones are loaded on
+	// scroll
 	var branch Branch
+	var branchStart int
 	if exists {
-		branch, err = findChatBranch(r.Context(), q, id, branchID)
+		var ok bool
+		branch, ok, err = findChatBranchInfo(r.Context(), q, id, branchID)
+		if ok {
+			branch.Messages, branchStart, err = findMessagesBefore(r.Context(), q, id, branchID, math.MaxInt64, messagePageSize)
+		}
+		if errors.Is(err, errTrashed) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
 	} else {
 		branch = Branch{ID: uuid.New()}
 	}
-	chat, err := findChat(r.Context(), q, id)
+	chat, err := findChatInfo(r.Context(), q, id)
 	if err != nil {
 		slog.Error("failed to find chat", "err", err.Error())
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
+	// Main page is loaded for the length of main, when the branch is shown
+	mainLimit := messagePageSize
+	if len(branch.Messages) > 0 {
+		mainLimit = 1
+	}
+	mainMsgs, mainStart, err := findMessagesBefore(r.Context(), q, id, mainBranchID, math.MaxInt64, mainLimit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	mainLen := mainStart + len(mainMsgs)
 	chatTitles, err := findChatsTitles(q)
 
 	if err != nil {
@@ -136,19 +239,55 @@ func (h ChatHandler) getChat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, messageGenerating := h.msgChan.Get(branch.ID)
+	// Find comments of displayed messages
+	shownBranchID, shown, start := mainBranchID, mainMsgs, mainStart
+	if len(branch.Messages) > 0 {
+		shownBranchID, shown, start = branch.ID, branch.Messages, branchStart
+	}
+	comments, err := findComments(r.Context(), q, chat.ID, shownBranchID)
+	if err != nil {
+		slog.Error("failed to find comments", "err", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	job, err := h.findJob(r.Context(), q, jobMessage, chat.ID, branch.ID.String())
+	if err != nil {
+		slog.Error("failed to find message job", "with", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var failedJob *jobView
+	if job != nil && !job.Pending() {
+		failedJob = job
+	}
+
+	messageGenerating := h.msgChan.Generating(r.Context(), branch.ID) || job.Pending()
+	var continuable bool
+	if n := len(shown); n > 0 && shown[n-1].Incomplete {
+		// Streamed message already contains the checkpointed text
+		if messageGenerating {
+			shown = shown[:n-1]
+		} else {
+			continuable = true
+		}
+	}
 	err = h.templates.Render(w, "index", ChatViewData{
 		Chat: ChatRender{
-			ID:       chat.ID,
-			Title:    chat.Title,
-			Messages: renderMessages(chat),
+			ID:    chat.ID,
+			Title: chat.Title,
 		},
 		Branch:            branch,
+		BranchInfo:        newBranchInfoView(chat.ID, mainLen, branch, h.baseURI),
+		Page:              newMessagePage(chat.ID, shownBranchID, start, shown, comments, h.baseURI),
 		ChatTitles:        chatTitles,
 		Keybinds:          web.Keybinds,
 		BaseURI:           h.baseURI,
 		GraphURI:          h.graphURI,
 		MessageGenerating: messageGenerating,
+		FailedJob:         failedJob,
+		Continuable:       continuable,
+		OpenMerge:         r.URL.Query().Get("merge") == "true",
 	})
 	if err != nil {
 		slog.Error("failed to render index page", "with", err.Error())
@@ -170,12 +309,13 @@ func (h ChatHandler) deleteChat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Delete chat
-	err = q.DeleteChat(r.Context(), id.String())
+	// Move chat to the trash
+	err = q.TrashChat(r.Context(), id.String())
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	publishFeed(r.Context(), feedEvent{Type: feedChatDeleted, ChatID: id.String()})
 
 	// Redirect
 	w.Header().Set("HX-Redirect", h.graphURI)
@@ -200,12 +340,47 @@ func (h ChatHandler) getBranches(w http.ResponseWriter, r *http.Request) {
 	}
 	slog.Info("found chat log", "length", len(log))
 
-	items := make([]branchTreeViewItem, len(log))
-	for i, l := range log {
-		items[i] = branchTreeViewItem{
-			Meta:   l.Meta,
-			Action: l.Action,
+	// Trashed branches are not shown
+	branches, err := findChatBranches(r.Context(), q, chatID, log)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Merges are listed under their branches
+	merges := findMerges(log)
+
+	// Abandoned branches are hidden unless requested
+	showAbandoned := r.URL.Query().Get("abandoned") == "true"
+	var abandonedAmount int
+	items := make([]branchTreeViewItem, 0, len(branches))
+	for _, b := range branches {
+		if b.Abandoned {
+			abandonedAmount++
+			if !showAbandoned {
+				continue
+			}
+		}
+		if h.generating(r.Context(), q, chatID, b.ID) {
+			b.State = BranchActive
 		}
+		item := branchTreeViewItem{
+			BranchID: b.ID.String(),
+			Name:     b.DisplayName(),
+			State:    b.State,
+		}
+		for _, m := range merges {
+			if m.BranchID == item.BranchID {
+				item.Merges = append(item.Merges, branchTreeMerge{
+					ID:       m.ID,
+					Amount:   m.Amount,
+					Squashed: m.Squashed,
+					Reverted: m.Reverted,
+					Detached: m.Detached,
+				})
+			}
+		}
+		items = append(items, item)
 	}
 
 	chat, err := findChat(r.Context(), q, chatID)
@@ -215,7 +390,17 @@ func (h ChatHandler) getBranches(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, titleGenerating := h.titleChan.Get(chatID)
+	titleJob, err := h.findJob(r.Context(), q, jobTitle, chatID, "")
+	if err != nil {
+		slog.Error("failed to find title job", "with", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	titleGenerating := h.titleChan.Generating(r.Context(), chatID) || titleJob.Pending()
+	var latestEventID string
+	if len(log) > 0 {
+		latestEventID = log[len(log)-1].ID
+	}
 
 	// Render response
 	err = h.templates.Render(w, "branch-tree", branchTreeView{
@@ -224,7 +409,16 @@ func (h ChatHandler) getBranches(w http.ResponseWriter, r *http.Request) {
 			ID:    chat.ID,
 			Title: chat.Title,
 		},
+		Flags: ChatFlags{
+			ID:       chat.ID.String(),
+			Pinned:   chat.Pinned,
+			Archived: chat.Archived,
+			BaseURI:  h.baseURI,
+		},
+		AbandonedAmount: abandonedAmount,
+		ShowAbandoned:   showAbandoned,
 		TitleGenerating: titleGenerating,
+		LatestEventID:   latestEventID,
 		BaseURI:         h.baseURI,
 	})
 	if err != nil {
@@ -235,14 +429,29 @@ func (h ChatHandler) getBranches(w http.ResponseWriter, r *http.Request) {
 
 type branchTreeView struct {
 	Items           []branchTreeViewItem
+	AbandonedAmount int
+	ShowAbandoned   bool
 	TitleGenerating bool
-	Chat            ChatRender
-	BaseURI         string
+	// Links history to the current state, empty without logged events
+	LatestEventID string
+	Chat          ChatRender
+	Flags         ChatFlags
+	BaseURI       string
 }
 
 type branchTreeViewItem struct {
-	Meta   ChatLogger
-	Action string
+	BranchID string
+	Name     string
+	State    BranchState
+	Merges   []branchTreeMerge
+}
+
+type branchTreeMerge struct {
+	ID       string
+	Amount   int
+	Squashed bool
+	Reverted bool
+	Detached bool
 }
 
 func (h ChatHandler) getEmptyChat(w http.ResponseWriter, r *http.Request) {
@@ -307,6 +516,12 @@ func (h ChatHandler) postUserMessage(w http.ResponseWriter, r *http.Request) {
 		errs = append(errs, err)
 	}
 
+	// Generated by the page for every prompt, optional
+	idempotencyKey := r.FormValue("idempotencyKey")
+	if len(idempotencyKey) > maxIdempotencyKeyLength {
+		errs = append(errs, fmt.Errorf("idempotency key should not be larger than %d chars", maxIdempotencyKeyLength))
+	}
+
 	if len(errs) > 0 {
 		http.Error(w, errors.Join(errs...).Error(), http.StatusBadRequest)
 		return
@@ -317,54 +532,48 @@ func (h ChatHandler) postUserMessage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Posts load the branch & save it back, so concurrent ones would lose
+	// messages. The answer is saved on top of the branch as well, so posts
+	// aren't accepted until it's generated
+	unlock := h.branchLocks.Lock(branchID)
+	defer unlock()
+
+	// Repeated submission gets the response of the original one, even while
+	// its answer is generated
+	if idempotencyKey != "" {
+		resp, ok, err := findPostResponse(r.Context(), q, id, branchID, idempotencyKey)
+		if err != nil {
+			slog.Error("failed to find post response", "with", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if ok {
+			slog.Info("repeated message post", "key", idempotencyKey)
+			resp.write(w)
+			return
+		}
+	}
+
+	if h.generating(r.Context(), q, id, branchID) {
+		http.Error(w, "Branch is generating a message", http.StatusConflict)
+		return
+	}
+
 	// Get chat
 	chat, err := findChat(r.Context(), q, id)
-	userMsg := Message{Text: prompt, Role: "user"}
+	userMsg := Message{Text: prompt, Role: "user", CreatedAt: time.Now().UTC()}
 	var newChatCreated bool
 	switch err {
 	case nil:
 		break
-	// TODO: Move to service
 	case sql.ErrNoRows:
-		// Create new chat
+		// New chat is created with the prompt
 		chat = Chat{
 			Title:    "New Chat",
 			ID:       id,
 			Messages: []Message{},
 		}
-		err := saveChat(r.Context(), q, chat)
-		if err != nil {
-			slog.Error("failed to initialize chat", "with", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
 		newChatCreated = true
-
-		// Generate title in background
-		titleCtx := context.Background()
-		go func() {
-			stream := h.titleChan.Alloc(id)
-			defer h.titleChan.Free(id)
-
-			// Generate title
-			t, err := genTitle(titleCtx, h.g, prompt)
-			if err != nil {
-				slog.Error("failed to generate title", "with", err)
-				return
-			}
-
-			// Publish title
-			stream.Chunks <- t
-
-			// Persist title
-			err = q.SaveChatTitle(titleCtx, db.SaveChatTitleParams{
-				ID:    chat.ID.String(),
-				Title: t,
-			})
-			if err != nil {
-				slog.Error("failed to save chat title", "with", err)
-			}
-		}()
 	default:
 		slog.Error("failed to find chat", "err", err.Error())
 		http.Error(w, err.Error(), http.StatusNotFound)
@@ -379,96 +588,105 @@ func (h ChatHandler) postUserMessage(w http.ResponseWriter, r *http.Request) {
 	}
 	branch.Messages = append(branch.Messages, userMsg)
 
-	// New branch should be created
-	if len(branch.Messages) == 1 {
-		err = updateBranchMessages(r.Context(), q, chat.ID, branch)
-		if err != nil {
-			slog.Error("failed to save new branch", "with", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		err = saveChatLog(r.Context(), q, chat.ID, LogBranchCreated{
-			BranchID:         branch.ID.String(),
-			OriginMessageIdx: len(chat.Messages) - 1,
-		})
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-	}
-
 	// Get mentioned chats
-	mentionedChats := make([]Chat, len(mentions))
+	mentionIDs := make([]string, len(mentions))
 	for i, v := range mentions {
 		// Find mentioned chat
-		m, err := findChat(r.Context(), q, v.ID)
+		_, err := findChat(r.Context(), q, v.ID)
 		if err != nil {
 			slog.Error("failed to find mentioned chat", "err", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		// Save used mention
-		err = q.SaveMention(r.Context(), db.SaveMentionParams{
-			TargetID: v.ID.String(),
-			SourceID: chat.ID.String(),
-		})
+		// Collect
+		mentionIDs[i] = v.ID.String()
+	}
+
+	// Response is prepared before saving, so it's stored with the key
+	var resp postResponse
+	if newChatCreated || len(branch.Messages) == 1 {
+		// Redirect to the new page
+		resp.Redirect = fmt.Sprintf("%s/%s/branch/%s", h.baseURI, chat.ID.String(), branchID.String())
+	} else {
+		// Render messages
+		var body bytes.Buffer
+		err = h.templates.Render(&body, "message", branch.Messages[len(branch.Messages)-1])
 		if err != nil {
-			slog.Error("failed to save a mention", "with", err)
+			slog.Error("failed to render user message", "with", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		view := StreamedMessageView{BaseURI: h.baseURI}
+		view.Chat.ID = chat.ID.String()
+		view.Branch.ID = branch.ID.String()
+		if err := h.templates.Render(&body, "streamed-message", view); err != nil {
+			slog.Error("failed to render streamed message", "with", err.Error())
 			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
-
-		// Collect
-		mentionedChats[i] = m
+		resp.Body = body.Bytes()
 	}
 
-	// Eval prompt
-	// TODO: Add timeout
-	go func(branch Branch) {
-		ctx := context.Background()
-		stream := h.msgChan.Alloc(branch.ID)
-		defer h.msgChan.Free(branch.ID)
+	// Prompt is saved in one transaction with the job answering it, the job
+	// answers the last user message of the branch
+	err = inTx(r.Context(), q, func(ctx context.Context, q *db.Queries) error {
+		if newChatCreated {
+			if err := saveChat(ctx, q, chat); err != nil {
+				slog.Error("failed to initialize chat", "with", err)
+				return err
+			}
 
-		msg, err := generateMessage(ctx, h.g,
-			slices.Concat(chat.Messages, branch.Messages),
-			mentionedChats,
-			stream.Chunks,
-		)
-		if err != nil {
-			slog.Error("failed to generate message", "with", err)
-			return
+			// Generate title in background
+			err := h.enqueueJob(ctx, q, jobTitle, id, "", titleJob{Prompt: prompt})
+			if err != nil {
+				return err
+			}
+		}
+
+		// New branch should be created & named in background
+		if len(branch.Messages) == 1 {
+			branch.Origin = len(chat.Messages) - 1
+			err = createBranch(ctx, q, chat.ID, &branch)
+			if err == nil {
+				err = h.enqueueJob(ctx, q, jobBranchName, chat.ID, branch.ID.String(), branchNameJob{Prompt: prompt})
+			}
+		} else {
+			err = updateBranchMessages(ctx, q, chat.ID, &branch)
 		}
-		branch.Messages = append(branch.Messages, msg)
-		err = updateBranchMessages(ctx, q, chat.ID, branch)
 		if err != nil {
-			slog.Error("failed to save chat after generation", "with", err)
+			slog.Error("failed to save user message", "with", err)
+			return err
 		}
-	}(branch)
 
-	// Redirect to the new page
-	if newChatCreated || len(branch.Messages) == 1 {
-		slog.Info("New chat & branch created")
-		w.Header().Set(
-			"HX-Redirect",
-			fmt.Sprintf("%s/%s/branch/%s", h.baseURI, chat.ID.String(), branchID.String()),
-		)
-		return
-	}
+		// Save used mentions
+		for _, mentionID := range mentionIDs {
+			err := q.SaveMention(ctx, db.SaveMentionParams{
+				TargetID: mentionID,
+				SourceID: chat.ID.String(),
+			})
+			if err != nil {
+				slog.Error("failed to save a mention", "with", err)
+				return err
+			}
+		}
 
-	// Render messages
-	err = h.templates.Render(w, "message", branch.Messages[len(branch.Messages)-1])
+		// Eval prompt in background, the job survives server restarts
+		err := h.enqueueJob(ctx, q, jobMessage, chat.ID, branch.ID.String(), messageJob{MentionIDs: mentionIDs})
+		if err != nil || idempotencyKey == "" {
+			return err
+		}
+		return savePostResponse(ctx, q, chat.ID, branch.ID, idempotencyKey, resp)
+	})
 	if err != nil {
-		slog.Error("failed to render user message", "with", err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeUpdateError(w, err)
+		return
 	}
-	view := StreamedMessageView{BaseURI: h.baseURI}
-	view.Chat.ID = chat.ID.String()
-	view.Branch.ID = branch.ID.String()
 
-	if err := h.templates.Render(w, "streamed-message", view); err != nil {
-		slog.Error("failed to render streamed message", "with", err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if newChatCreated || len(branch.Messages) == 1 {
+		slog.Info("New chat & branch created")
 	}
+	resp.write(w)
 }
 
 type StreamedMessageView struct {
@@ -489,15 +707,37 @@ func (h ChatHandler) getTitle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Wait for the generated title
-	stream, ok := h.titleChan.Get(id)
-	if !ok {
-		http.Error(w, "There is no any generating title", http.StatusNotFound)
+	q, err := h.getQueries(w, r)
+	if err != nil {
 		return
 	}
 
-	// Render result
-	title := <-stream.Chunks
+	// Wait for the generated title, its job could be still queued
+	chunks, ok := h.titleChan.Subscribe(r.Context(), id)
+	for !ok {
+		job, err := h.findJob(r.Context(), q, jobTitle, id, "")
+		if err != nil {
+			slog.Error("failed to find title job", "with", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !job.Pending() {
+			http.Error(w, "There is no any generating title", http.StatusNotFound)
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(jobWaitInterval):
+		}
+		chunks, ok = h.titleChan.Subscribe(r.Context(), id)
+	}
+
+	// Render result, title is published as a whole
+	var title string
+	for chunk := range chunks {
+		title += chunk
+	}
 	_, err = w.Write([]byte(title))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -505,57 +745,174 @@ func (h ChatHandler) getTitle(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h ChatHandler) getMessageStream(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-
+	// Validate data
+	chatID, err := deserID(w, r)
+	var errs []error
+	if err != nil {
+		errs = append(errs, err)
+	}
 	branchID, _, err := deserBranchID(w, r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+		errs = append(errs, err)
 	}
-
-	stream, ok := h.msgChan.Get(branchID)
-	if !ok {
-		sse.Send(w, sse.Event{
-			Type: "finished",
-			Data: "There is no stream",
-		})
+	if len(errs) > 0 {
+		http.Error(w, errors.Join(errs...).Error(), http.StatusBadRequest)
 		return
 	}
 
-	msg := &HTMLMessage{
-		Role: "model",
+	q, err := h.getQueries(w, r)
+	if err != nil {
+		return
 	}
 
-	var raw string
+	events := sse.NewWriter(w, r)
+	heartbeat := time.NewTicker(sse.HeartbeatInterval)
+	defer heartbeat.Stop()
 
-loop:
+	// Chunk events are identified by the length of the text so far, so the
+	// reconnected client gets only the text it missed. Text of the retried
+	// attempt continues the numbering
+	var sent int64
+	var streamed bool
+	var shownStatus string
 	for {
+		chunks, ok := h.msgChan.Subscribe(r.Context(), branchID)
+		if ok {
+			streamed = true
+			n, err := h.sendMessageChunks(events, chunks, heartbeat.C, sent)
+			if err != nil {
+				slog.Error("failed to send message chunk", "with", err)
+				return
+			}
+			sent += n
+			// Subscription is also closed when the client is gone
+			if r.Context().Err() != nil {
+				return
+			}
+		}
+
+		// Queued generation starts its stream later, failed attempts are
+		// retried after a delay
+		job, err := h.findJob(r.Context(), q, jobMessage, chatID, branchID.String())
+		if err != nil {
+			slog.Error("failed to find message job", "with", err)
+			return
+		}
+		if !job.Pending() {
+			switch {
+			case job != nil:
+				err = h.sendJobStatus(events, job)
+			case !streamed && events.Resumed():
+				// Generation could finish while the client was reconnecting
+				h.sendGeneratedMessage(r.Context(), q, events, chatID, branchID)
+			}
+			if err != nil {
+				slog.Error("failed to send job status", "with", err)
+				return
+			}
+			break
+		}
+		if status := fmt.Sprintf("%s-%d", job.Status, job.Attempts); !ok && status != shownStatus {
+			if err := h.sendJobStatus(events, job); err != nil {
+				slog.Error("failed to send job status", "with", err)
+				return
+			}
+			shownStatus = status
+		}
+
 		select {
-		case chunk := <-stream.Chunks:
-			raw += chunk
-			msg.Text = markdownToHTML(raw)
-			var tpl bytes.Buffer
-			if err := h.templates.Render(&tpl, "message", msg); err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if err := events.Heartbeat(); err != nil {
+				slog.Error("failed to send heartbeat", "with", err)
 				return
 			}
-			sse.Send(w, sse.Event{
-				Type: "chunk",
-				Data: strings.Replace(tpl.String(), "\n", "", -1),
-			})
-		case <-stream.Done:
-			break loop
+		case <-time.After(jobWaitInterval):
 		}
 	}
 
-	sse.Send(w, sse.Event{
+	err = events.Send(sse.Event{
 		Type: "finished",
 		Data: "",
 	})
+	if err != nil {
+		slog.Error("failed to finish message stream", "with", err)
+	}
+}
+
+// Sends chunks of a single generation attempt until the stream ends, returns
+// length of the received text
+func (h ChatHandler) sendMessageChunks(events *sse.Writer, chunks <-chan string, heartbeat <-chan time.Time, sent int64) (int64, error) {
+	var raw string
+	for {
+		select {
+		case chunk, ok := <-chunks:
+			if !ok {
+				return int64(len(raw)), nil
+			}
+			raw += chunk
+			id := sent + int64(len(raw))
+			if id <= events.LastID {
+				continue
+			}
+			if err := h.sendMessageChunk(events, id, raw); err != nil {
+				return 0, err
+			}
+		case <-heartbeat:
+			if err := events.Heartbeat(); err != nil {
+				return 0, err
+			}
+		}
+	}
+}
+
+// Sends message rendered from the whole text received so far
+func (h ChatHandler) sendMessageChunk(events *sse.Writer, id int64, raw string) error {
+	msg := &HTMLMessage{
+		Role: "model",
+		Text: markdownToHTML(raw),
+	}
+	var tpl bytes.Buffer
+	if err := h.templates.Render(&tpl, "message", msg); err != nil {
+		return err
+	}
+	return events.Send(sse.Event{
+		ID:   id,
+		Type: "chunk",
+		Data: strings.Replace(tpl.String(), "\n", "", -1),
+	})
+}
+
+// Shows state of the queued or failed generation in place of the message
+func (h ChatHandler) sendJobStatus(events *sse.Writer, job *jobView) error {
+	var tpl bytes.Buffer
+	if err := h.templates.Render(&tpl, "job-status", job); err != nil {
+		return err
+	}
+	return events.Send(sse.Event{
+		Type: "chunk",
+		Data: strings.Replace(tpl.String(), "\n", "", -1),
+	})
+}
 
-	<-r.Context().Done()
+// Sends the saved answer which the client hasn't received completely
+func (h ChatHandler) sendGeneratedMessage(ctx context.Context, q *db.Queries, events *sse.Writer, chatID, branchID uuid.UUID) {
+	msgs, err := findMessages(ctx, q, chatID, branchID)
+	if err != nil {
+		slog.Error("failed to find generated message", "with", err)
+		return
+	}
+	if len(msgs) == 0 || msgs[len(msgs)-1].Role != "model" {
+		return
+	}
+	raw := msgs[len(msgs)-1].Text
+	if int64(len(raw)) <= events.LastID {
+		return
+	}
+	if err := h.sendMessageChunk(events, int64(len(raw)), raw); err != nil {
+		slog.Error("failed to send generated message", "with", err)
+	}
 }
 
 func (h ChatHandler) getMergeStatus(w http.ResponseWriter, r *http.Request) {
@@ -581,16 +938,25 @@ func (h ChatHandler) getMergeStatus(w http.ResponseWriter, r *http.Request) {
 	}
 
 	branch, err := findChatBranch(r.Context(), q, chatID, branchID)
+	if err == nil {
+		err = loadBranchState(r.Context(), q, chatID, &branch)
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	if len(branch.Messages) < 2 {
+	// Nothing to merge while message is being generated
+	generating := h.generating(r.Context(), q, chatID, branch.ID)
+	if generating || !branch.Mergeable() {
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
 
-	err = h.templates.Render(w, "merge-button", mergeButtonView{BranchID: branch.ID.String()})
+	err = h.templates.Render(w, "merge-button", mergeButtonView{
+		BranchID: branch.ID.String(),
+		Open:     r.URL.Query().Get("merge") == "true",
+	})
 	if err != nil {
 		slog.Error("failed to render tempalte", "with", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -600,6 +966,7 @@ func (h ChatHandler) getMergeStatus(w http.ResponseWriter, r *http.Request) {
 
 type mergeButtonView struct {
 	BranchID string
+	Open     bool
 }
 
 func (h ChatHandler) getMerge(w http.ResponseWriter, r *http.Request) {
@@ -626,13 +993,23 @@ func (h ChatHandler) getMerge(w http.ResponseWriter, r *http.Request) {
 
 	// Find branch
 	branch, err := findChatBranch(r.Context(), q, chatID, branchID)
+	if err == nil {
+		err = loadBranchState(r.Context(), q, chatID, &branch)
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !branch.Mergeable() {
+		http.Error(w, "Branch has nothing to merge", http.StatusBadRequest)
+		return
 	}
 
-	// TODO: Decompose into separate function (look `getMergeStatus`)
-	if len(branch.Messages) < 2 {
-		http.Error(w, "Branch should countain at least 2 messages", http.StatusBadRequest)
+	// Unresolved comments are shown to prevent unreviewed merges
+	comments, err := findUnresolvedComments(r.Context(), q, chatID, branch.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
@@ -645,19 +1022,39 @@ func (h ChatHandler) getMerge(w http.ResponseWriter, r *http.Request) {
 			Selected: i == 0 || i == len(branch.Messages)-1,
 		}
 	}
+	for _, c := range comments {
+		if c.MessageIdx >= 0 && c.MessageIdx < len(items) {
+			items[c.MessageIdx].UnresolvedComments++
+		}
+	}
 
 	// Render tempalte
-	if err := h.templates.Render(w, "merge", items); err != nil {
+	if err := h.templates.Render(w, "merge", mergeView{
+		ChatID:   chatID.String(),
+		BranchID: branch.ID.String(),
+		Items:    items,
+		Comments: comments,
+		BaseURI:  h.baseURI,
+	}); err != nil {
 		slog.Error("failed to render tempalte", "with", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 }
 
+type mergeView struct {
+	ChatID   string
+	BranchID string
+	Items    []mergeViewItem
+	Comments []Comment
+	BaseURI  string
+}
+
 type mergeViewItem struct {
-	ID       int
-	Message  HTMLMessage
-	Selected bool
+	ID                 int
+	Message            HTMLMessage
+	Selected           bool
+	UnresolvedComments int
 }
 
 func (h ChatHandler) postMerge(w http.ResponseWriter, r *http.Request) {
@@ -686,13 +1083,43 @@ func (h ChatHandler) postMerge(w http.ResponseWriter, r *http.Request) {
 	branch, err := findChatBranch(r.Context(), q, chatID, branchID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if branch.Abandoned {
+		http.Error(w, "Abandoned branch should be reopened before merging", http.StatusBadRequest)
+		return
 	}
 
 	var toMerge []Message
-	for idx, msg := range branch.Messages {
-		selected := r.FormValue(fmt.Sprintf("merge-item-%d", idx))
-		if selected == "on" {
-			toMerge = append(toMerge, msg)
+	var mergedIdxs []int
+	squashed := r.FormValue("mode") == "squash"
+	if squashed {
+		// Whole branch is merged as the single summary message
+		summary := strings.TrimSpace(r.FormValue("summary"))
+		if summary == "" {
+			http.Error(w, "Squash summary can not be empty", http.StatusBadRequest)
+			return
+		}
+		if len(summary) > maxSquashSummaryLength {
+			http.Error(w, fmt.Sprintf("Squash summary should not be larger than %d chars", maxSquashSummaryLength), http.StatusBadRequest)
+			return
+		}
+		toMerge = []Message{{
+			Text:         summary,
+			Role:         "model",
+			SquashedFrom: branch.ID.String(),
+			CreatedAt:    time.Now().UTC(),
+		}}
+		for idx := range branch.Messages {
+			mergedIdxs = append(mergedIdxs, idx)
+		}
+	} else {
+		for idx, msg := range branch.Messages {
+			selected := r.FormValue(fmt.Sprintf("merge-item-%d", idx))
+			if selected == "on" {
+				toMerge = append(toMerge, msg)
+				mergedIdxs = append(mergedIdxs, idx)
+			}
 		}
 	}
 
@@ -708,22 +1135,17 @@ func (h ChatHandler) postMerge(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = saveChatLog(r.Context(), q, chatID, LogBranchMerged{
-		BranchID:           branch.ID.String(),
-		MergedAmount:       len(toMerge),
-		MergedAtMessageIdX: len(chat.Messages) - 1,
+	err = mergeMessages(r.Context(), q, &chat, toMerge, LogBranchMerged{
+		MergeID:     uuid.NewString(),
+		BranchID:    branch.ID.String(),
+		MessageIdxs: mergedIdxs,
+		Squashed:    squashed,
 	})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeUpdateError(w, err)
 		return
 	}
 
-	chat.Messages = slices.Concat(chat.Messages, toMerge)
-	err = updateChatMessages(r.Context(), q, chat)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-	}
-
 	w.Header().Set("HX-Redirect", fmt.Sprintf("%s/%s", h.baseURI, chatID))
 }
 
@@ -768,6 +1190,7 @@ func (h ChatHandler) postTags(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	publishFeed(r.Context(), feedEvent{Type: feedTagsChanged, ChatID: id.String()})
 
 	// Render new tag
 	err = h.templates.Render(w, "tag", Tag{
@@ -852,6 +1275,7 @@ func (h ChatHandler) deleteTags(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	publishFeed(r.Context(), feedEvent{Type: feedTagsChanged, ChatID: id.String()})
 }
 
 func (h ChatHandler) getQueries(w http.ResponseWriter, r *http.Request) (*db.Queries, error) {
@@ -863,6 +1287,16 @@ func (h ChatHandler) getQueries(w http.ResponseWriter, r *http.Request) (*db.Que
 	return q, err
 }
 
+// Answers failed update of messages, conflicts ask the page for a refresh
+func writeUpdateError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errConflict) {
+		w.Header().Set("HX-Trigger", "chatConflict")
+		http.Error(w, "Chat was changed by another request, refresh the page", http.StatusConflict)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
 func deserID(w http.ResponseWriter, r *http.Request) (id uuid.UUID, err error) {
 	id, err = uuid.Parse(r.PathValue("id"))
 	if err != nil {
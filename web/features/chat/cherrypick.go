@@ -0,0 +1,256 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"github.com/google/uuid"
+
+	"shellshift/internal/db"
+)
+
+// Messages of the branch or of the main when main branch ID is passed
+func findBranchMessages(ctx context.Context, q *db.Queries, chat Chat, branchID uuid.UUID) ([]Message, error) {
+	if branchID == mainBranchID {
+		return chat.Messages, nil
+	}
+	b, err := findChatBranch(ctx, q, chat.ID, branchID)
+	if err != nil {
+		return nil, err
+	}
+	return b.Messages, nil
+}
+
+// Indexes of source messages which were already picked into the target
+func findPickedIdxs(log []LogEntry, sourceID, targetID uuid.UUID) map[int]bool {
+	picked := map[int]bool{}
+	for _, entry := range log {
+		p, ok := entry.Meta.(LogMessagesCherryPicked)
+		if !ok || p.SourceBranchID != sourceID.String() || p.TargetBranchID != targetID.String() {
+			continue
+		}
+		for _, idx := range p.MessageIdxs {
+			picked[idx] = true
+		}
+	}
+	return picked
+}
+
+type cherryPickView struct {
+	ChatID   string
+	SourceID string
+	Items    []cherryPickItem
+	Targets  []branchOption
+	BaseURI  string
+}
+
+type cherryPickItem struct {
+	ID      int
+	Message HTMLMessage
+}
+
+func (h ChatHandler) getCherryPick(w http.ResponseWriter, r *http.Request) {
+	// Validate data
+	chatID, err := deserID(w, r)
+	var errs []error
+	if err != nil {
+		errs = append(errs, err)
+	}
+	// Branch param always exists because of routing
+	sourceID, _, err := deserBranchID(w, r)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		http.Error(w, errors.Join(errs...).Error(), http.StatusBadRequest)
+		return
+	}
+
+	q, err := h.getQueries(w, r)
+	if err != nil {
+		return
+	}
+
+	chat, err := findChat(r.Context(), q, chatID)
+	if err != nil {
+		slog.Error("failed to find chat", "err", err.Error())
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	msgs, err := findBranchMessages(r.Context(), q, chat, sourceID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(msgs) == 0 {
+		http.Error(w, "Branch has nothing to pick", http.StatusBadRequest)
+		return
+	}
+
+	// Any other branch may be a target
+	log, err := findChatLog(r.Context(), q, chatID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	branches, err := findChatBranches(r.Context(), q, chatID, log)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var targets []branchOption
+	if sourceID != mainBranchID {
+		targets = append(targets, branchOption{ID: mainBranchID.String(), Name: "Main"})
+	}
+	for _, b := range branches {
+		if b.ID != sourceID {
+			targets = append(targets, branchOption{ID: b.ID.String(), Name: b.DisplayName()})
+		}
+	}
+
+	items := make([]cherryPickItem, len(msgs))
+	for i, msg := range msgs {
+		items[i] = cherryPickItem{ID: i, Message: renderMessage(msg)}
+	}
+
+	err = h.templates.Render(w, "cherry-pick", cherryPickView{
+		ChatID:   chatID.String(),
+		SourceID: sourceID.String(),
+		Items:    items,
+		Targets:  targets,
+		BaseURI:  h.baseURI,
+	})
+	if err != nil {
+		slog.Error("failed to render tempalte", "with", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (h ChatHandler) postCherryPick(w http.ResponseWriter, r *http.Request) {
+	// Validate data
+	chatID, err := deserID(w, r)
+	var errs []error
+	if err != nil {
+		errs = append(errs, err)
+	}
+	// Branch param always exists because of routing
+	sourceID, _, err := deserBranchID(w, r)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	targetID, err := parseBranchRef(r.FormValue("target"))
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to parse target branch with %w", err))
+	}
+	if err == nil && targetID == sourceID {
+		errs = append(errs, fmt.Errorf("messages can't be picked into the same branch"))
+	}
+	if len(errs) > 0 {
+		http.Error(w, errors.Join(errs...).Error(), http.StatusBadRequest)
+		return
+	}
+
+	q, err := h.getQueries(w, r)
+	if err != nil {
+		return
+	}
+
+	// Picked messages would be lost on generation finish
+	if h.generating(r.Context(), q, chatID, targetID) {
+		http.Error(w, "Target branch is generating a message", http.StatusConflict)
+		return
+	}
+
+	chat, err := findChat(r.Context(), q, chatID)
+	if err != nil {
+		slog.Error("failed to find chat", "err", err.Error())
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	source, err := findBranchMessages(r.Context(), q, chat, sourceID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var target Branch
+	targetMsgs := chat.Messages
+	if targetID != mainBranchID {
+		target, err = findChatBranch(r.Context(), q, chatID, targetID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(target.Messages) == 0 {
+			http.Error(w, "Target branch doesn't exist", http.StatusNotFound)
+			return
+		}
+		targetMsgs = target.Messages
+	}
+
+	log, err := findChatLog(r.Context(), q, chatID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Collect selected messages in their order, skipping duplicates
+	picked := findPickedIdxs(log, sourceID, targetID)
+	var toPick []Message
+	var pickedIdxs []int
+	var selected int
+	for idx, msg := range source {
+		if r.FormValue(fmt.Sprintf("pick-item-%d", idx)) != "on" {
+			continue
+		}
+		selected++
+		if picked[idx] || slices.ContainsFunc(targetMsgs, func(t Message) bool { return t.key() == msg.key() }) {
+			slog.Info("skipping already picked message", "idx", idx)
+			continue
+		}
+		toPick = append(toPick, msg)
+		pickedIdxs = append(pickedIdxs, idx)
+	}
+	if selected == 0 {
+		http.Error(w, "At least one message should be picked", http.StatusBadRequest)
+		return
+	}
+	if len(toPick) == 0 {
+		http.Error(w, "Selected messages were already picked", http.StatusConflict)
+		return
+	}
+
+	// Append messages to the target
+	err = inTx(r.Context(), q, func(ctx context.Context, q *db.Queries) error {
+		var err error
+		if targetID == mainBranchID {
+			chat.Messages = slices.Concat(chat.Messages, toPick)
+			err = updateChatMessages(ctx, q, &chat)
+		} else {
+			target.Messages = slices.Concat(target.Messages, toPick)
+			err = updateBranchMessages(ctx, q, chatID, &target)
+		}
+		if err != nil {
+			return err
+		}
+		return saveChatLog(ctx, q, chatID, LogMessagesCherryPicked{
+			SourceBranchID: sourceID.String(),
+			TargetBranchID: targetID.String(),
+			MessageIdxs:    pickedIdxs,
+		})
+	})
+	if err != nil {
+		writeUpdateError(w, err)
+		return
+	}
+
+	// Redirect to the target
+	if targetID == mainBranchID {
+		w.Header().Set("HX-Redirect", fmt.Sprintf("%s/%s", h.baseURI, chatID))
+		return
+	}
+	w.Header().Set("HX-Redirect", fmt.Sprintf("%s/%s/branch/%s", h.baseURI, chatID, targetID))
+}
@@ -0,0 +1,410 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"shellshift/internal/db"
+)
+
+type combineMode string
+
+const (
+	// Source messages are placed after the target ones
+	combineAppend combineMode = "append"
+	// Messages are ordered by their creation time
+	combineInterleave combineMode = "interleave"
+)
+
+// Combines messages of two chats. Returned positions map old message
+// indexes to the combined ones
+func combineMessages(target, source []Message, mode combineMode) (msgs []Message, targetPos, sourcePos []int) {
+	targetPos = make([]int, len(target))
+	sourcePos = make([]int, len(source))
+	i, j := 0, 0
+	for i < len(target) || j < len(source) {
+		// Target goes first on equal timestamps, messages without them
+		// are considered the oldest
+		takeTarget := j == len(source) ||
+			i < len(target) && (mode == combineAppend || !source[j].CreatedAt.Before(target[i].CreatedAt))
+		if takeTarget {
+			targetPos[i] = len(msgs)
+			msgs = append(msgs, target[i])
+			i++
+		} else {
+			sourcePos[j] = len(msgs)
+			msgs = append(msgs, source[j])
+			j++
+		}
+	}
+	return
+}
+
+// Maps branch origin to the combined messages
+func combinedOrigin(origin int, pos []int, emptyOrigin int) int {
+	if origin < 0 || len(pos) == 0 {
+		return emptyOrigin
+	}
+	return pos[min(origin, len(pos)-1)]
+}
+
+// Moves main comments of the chat to the new positions
+func moveMainComments(ctx context.Context, q *db.Queries, sourceID, targetID uuid.UUID, pos func(idx int) (int, bool)) error {
+	comments, err := findComments(ctx, q, sourceID, mainBranchID)
+	if err != nil {
+		return err
+	}
+	for _, c := range comments {
+		idx, ok := pos(c.MessageIdx)
+		if !ok || (idx == c.MessageIdx && sourceID == targetID) {
+			continue
+		}
+		err = q.MoveComment(ctx, db.MoveCommentParams{
+			ChatID:     targetID.String(),
+			MessageIdx: int64(idx),
+			ID:         c.ID,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to move comment with %w", err)
+		}
+	}
+	return nil
+}
+
+// Moves branch with its comments to another chat, where it is based on origin
+func moveBranch(ctx context.Context, q *db.Queries, sourceID, targetID, branchID uuid.UUID, origin int) error {
+	msgs, err := findMessages(ctx, q, sourceID, branchID)
+	if err != nil {
+		return err
+	}
+	err = q.MoveChatBranch(ctx, db.MoveChatBranchParams{
+		TargetID: targetID.String(),
+		SourceID: sourceID.String(),
+		ID:       branchID.String(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to move branch with %w", err)
+	}
+	err = q.MoveBranchComments(ctx, db.MoveBranchCommentsParams{
+		TargetID: targetID.String(),
+		SourceID: sourceID.String(),
+		BranchID: branchID.String(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to move branch comments with %w", err)
+	}
+	err = q.MoveBranchMessages(ctx, db.MoveBranchMessagesParams{
+		TargetID: targetID.String(),
+		SourceID: sourceID.String(),
+		BranchID: branchID.String(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to move branch messages with %w", err)
+	}
+	err = logMessagesChange(ctx, q, sourceID, branchID, msgs, nil)
+	if err != nil {
+		return err
+	}
+	err = q.UpdateChatBranchOrigin(ctx, db.UpdateChatBranchOriginParams{
+		Origin: int64(origin),
+		ChatID: targetID.String(),
+		ID:     branchID.String(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update branch origin with %w", err)
+	}
+	err = saveChatLog(ctx, q, targetID, LogBranchCreated{
+		BranchID:         branchID.String(),
+		OriginMessageIdx: origin,
+	})
+	if err != nil {
+		return err
+	}
+	return logMessagesChange(ctx, q, targetID, branchID, nil, msgs)
+}
+
+func (h ChatHandler) postSplit(w http.ResponseWriter, r *http.Request) {
+	// Validate data
+	chatID, err := deserID(w, r)
+	var errs []error
+	if err != nil {
+		errs = append(errs, err)
+	}
+	splitIdx, err := strconv.Atoi(r.FormValue("messageIdx"))
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to parse message index with %w", err))
+	}
+	title := strings.TrimSpace(r.FormValue("title"))
+	if len(errs) > 0 {
+		http.Error(w, errors.Join(errs...).Error(), http.StatusBadRequest)
+		return
+	}
+
+	q, err := h.getQueries(w, r)
+	if err != nil {
+		return
+	}
+
+	chat, err := findChat(r.Context(), q, chatID)
+	if err != nil {
+		slog.Error("failed to find chat", "err", err.Error())
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if splitIdx <= 0 || splitIdx >= len(chat.Messages) {
+		http.Error(w, "Both chats should keep at least one message", http.StatusBadRequest)
+		return
+	}
+	log, err := findChatLog(r.Context(), q, chatID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	branches, err := findChatBranches(r.Context(), q, chatID, log)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Create chat from the messages after the split index
+	if title == "" {
+		title = chat.Title + " (split)"
+	}
+	split := Chat{
+		ID:       uuid.New(),
+		Title:    title,
+		Messages: slices.Clone(chat.Messages[splitIdx:]),
+	}
+	chat.Messages = chat.Messages[:splitIdx]
+	err = inTx(r.Context(), q, func(ctx context.Context, q *db.Queries) error {
+		if err := saveChat(ctx, q, split); err != nil {
+			return err
+		}
+		if err := updateChatMessages(ctx, q, &chat); err != nil {
+			return err
+		}
+
+		// Carry over tags & leave the link behind
+		err := q.CopyChatTags(ctx, db.CopyChatTagsParams{
+			TargetID: split.ID.String(),
+			SourceID: chatID.String(),
+		})
+		if err == nil {
+			err = q.SaveMention(ctx, db.SaveMentionParams{
+				SourceID: chatID.String(),
+				TargetID: split.ID.String(),
+			})
+		}
+		if err != nil {
+			slog.Error("failed to link split chat", "with", err)
+			return err
+		}
+
+		// Comments & branches of the moved messages follow them
+		err = moveMainComments(ctx, q, chatID, split.ID, func(idx int) (int, bool) {
+			return idx - splitIdx, idx >= splitIdx
+		})
+		if err != nil {
+			return err
+		}
+		for _, b := range branches {
+			if b.Origin < splitIdx {
+				continue
+			}
+			if err := moveBranch(ctx, q, chatID, split.ID, b.ID, b.Origin-splitIdx); err != nil {
+				slog.Error("failed to move branch to split chat", "id", b.ID, "with", err)
+				return err
+			}
+		}
+
+		err = saveChatLog(ctx, q, chatID, LogChatSplit{
+			NewChatID:  split.ID.String(),
+			MessageIdx: splitIdx,
+		})
+		if err != nil {
+			return err
+		}
+		return saveChatLog(ctx, q, split.ID, LogChatSplitFrom{
+			SourceChatID: chatID.String(),
+			MessageIdx:   splitIdx,
+		})
+	})
+	if err != nil {
+		writeUpdateError(w, err)
+		return
+	}
+
+	w.Header().Set("HX-Redirect", fmt.Sprintf("%s/%s", h.baseURI, split.ID))
+}
+
+// Combines source chat into the target one, the source is moved to the trash
+func (h ChatHandler) postCombine(w http.ResponseWriter, r *http.Request) {
+	// Validate data
+	targetID, err := deserID(w, r)
+	var errs []error
+	if err != nil {
+		errs = append(errs, err)
+	}
+	sourceID, err := uuid.Parse(r.FormValue("source"))
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to parse source chat with %w", err))
+	}
+	if err == nil && sourceID == targetID {
+		errs = append(errs, fmt.Errorf("chat can't be combined with itself"))
+	}
+	mode := combineMode(r.FormValue("mode"))
+	if mode != combineAppend && mode != combineInterleave {
+		errs = append(errs, fmt.Errorf("unknown combine mode %q", mode))
+	}
+	if len(errs) > 0 {
+		http.Error(w, errors.Join(errs...).Error(), http.StatusBadRequest)
+		return
+	}
+
+	q, err := h.getQueries(w, r)
+	if err != nil {
+		return
+	}
+
+	target, err := findChat(r.Context(), q, targetID)
+	if err != nil {
+		slog.Error("failed to find chat", "err", err.Error())
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	source, err := findChat(r.Context(), q, sourceID)
+	if err != nil {
+		slog.Error("failed to find chat", "err", err.Error())
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	targetLog, err := findChatLog(r.Context(), q, targetID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sourceLog, err := findChatLog(r.Context(), q, sourceID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	targetBranches, err := findChatBranches(r.Context(), q, targetID, targetLog)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sourceBranches, err := findChatBranches(r.Context(), q, sourceID, sourceLog)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Combine main messages
+	msgs, targetPos, sourcePos := combineMessages(target.Messages, source.Messages, mode)
+	target.Messages = msgs
+
+	// Source branches based on empty main see the whole appended target
+	emptyOrigin := -1
+	if mode == combineAppend {
+		emptyOrigin = len(targetPos) - 1
+	}
+	posOf := func(pos []int) func(int) (int, bool) {
+		return func(idx int) (int, bool) {
+			if idx < 0 || idx >= len(pos) {
+				return idx, false
+			}
+			return pos[idx], true
+		}
+	}
+	err = inTx(r.Context(), q, func(ctx context.Context, q *db.Queries) error {
+		if err := updateChatMessages(ctx, q, &target); err != nil {
+			return err
+		}
+
+		// Re-point tags & mentions to the surviving chat
+		err := q.CopyChatTags(ctx, db.CopyChatTagsParams{
+			TargetID: targetID.String(),
+			SourceID: sourceID.String(),
+		})
+		if err == nil {
+			err = q.MoveMentionSources(ctx, db.MoveMentionSourcesParams{
+				TargetID: targetID.String(),
+				SourceID: sourceID.String(),
+			})
+		}
+		if err == nil {
+			err = q.MoveMentionTargets(ctx, db.MoveMentionTargetsParams{
+				TargetID: targetID.String(),
+				SourceID: sourceID.String(),
+			})
+		}
+		if err != nil {
+			slog.Error("failed to re-point tags & mentions", "with", err)
+			return err
+		}
+
+		// Re-point comments & branches, target comments are moved first, so
+		// source ones aren't moved twice
+		if mode == combineInterleave {
+			err = moveMainComments(ctx, q, targetID, targetID, posOf(targetPos))
+		}
+		if err == nil {
+			err = moveMainComments(ctx, q, sourceID, targetID, posOf(sourcePos))
+		}
+		if err != nil {
+			return err
+		}
+		for _, b := range sourceBranches {
+			origin := combinedOrigin(b.Origin, sourcePos, emptyOrigin)
+			if err := moveBranch(ctx, q, sourceID, targetID, b.ID, origin); err != nil {
+				slog.Error("failed to move branch to combined chat", "id", b.ID, "with", err)
+				return err
+			}
+		}
+		if mode == combineInterleave {
+			for _, b := range targetBranches {
+				origin := combinedOrigin(b.Origin, targetPos, -1)
+				if origin == b.Origin {
+					continue
+				}
+				err = rebaseBranch(ctx, q, targetID, LogBranchRebased{
+					BranchID:         b.ID.String(),
+					OriginMessageIdx: origin,
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		// Log in both chats & trash the source
+		combined := LogChatsCombined{
+			SourceChatID: sourceID.String(),
+			TargetChatID: targetID.String(),
+			Mode:         mode,
+		}
+		err = saveChatLog(ctx, q, targetID, combined)
+		if err == nil {
+			err = saveChatLog(ctx, q, sourceID, combined)
+		}
+		if err == nil {
+			err = q.TrashChat(ctx, sourceID.String())
+		}
+		return err
+	})
+	if err != nil {
+		writeUpdateError(w, err)
+		return
+	}
+	publishFeed(r.Context(), feedEvent{Type: feedChatDeleted, ChatID: sourceID.String()})
+
+	w.Header().Set("HX-Redirect", fmt.Sprintf("%s/%s", h.baseURI, targetID))
+}
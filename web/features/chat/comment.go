@@ -0,0 +1,338 @@
+package chat
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"shellshift/internal/db"
+)
+
+// Main messages don't belong to any branch, so their comments are stored
+// under the nil UUID
+var mainBranchID = uuid.Nil
+
+type Comment struct {
+	ID         string
+	ChatID     string
+	MessageIdx int
+	Text       string
+	Resolved   bool
+	BaseURI    string
+}
+
+// Comments attached to a single rendered message
+type MessageComments struct {
+	ChatID     string
+	BranchID   string
+	MessageIdx int
+	Comments   []Comment
+	BaseURI    string
+}
+
+func findComments(ctx context.Context, q *db.Queries, chatID, branchID uuid.UUID) ([]Comment, error) {
+	rows, err := q.FindComments(ctx, db.FindCommentsParams{
+		ChatID:   chatID.String(),
+		BranchID: branchID.String(),
+	})
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to find comments with %w", err)
+	}
+	comments := make([]Comment, len(rows))
+	for i, row := range rows {
+		comments[i] = Comment{
+			ID:         row.ID,
+			ChatID:     chatID.String(),
+			MessageIdx: int(row.MessageIdx),
+			Text:       row.Text,
+			Resolved:   row.Resolved,
+		}
+	}
+	return comments, nil
+}
+
+func findUnresolvedComments(ctx context.Context, q *db.Queries, chatID, branchID uuid.UUID) ([]Comment, error) {
+	rows, err := q.FindUnresolvedComments(ctx, db.FindUnresolvedCommentsParams{
+		ChatID:   chatID.String(),
+		BranchID: branchID.String(),
+	})
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to find unresolved comments with %w", err)
+	}
+	comments := make([]Comment, len(rows))
+	for i, row := range rows {
+		comments[i] = Comment{
+			ID:         row.ID,
+			ChatID:     chatID.String(),
+			MessageIdx: int(row.MessageIdx),
+			Text:       row.Text,
+		}
+	}
+	return comments, nil
+}
+
+// Groups comments by the message they belong to. Result always has
+// `msgsAmount` items, so it can be indexed along with rendered messages
+func groupComments(comments []Comment, chatID, branchID uuid.UUID, msgsAmount int, baseURI string) []MessageComments {
+	grouped := make([]MessageComments, msgsAmount)
+	for i := range grouped {
+		grouped[i] = MessageComments{
+			ChatID:     chatID.String(),
+			BranchID:   branchID.String(),
+			MessageIdx: i,
+			BaseURI:    baseURI,
+		}
+	}
+	for _, c := range comments {
+		if c.MessageIdx < 0 || c.MessageIdx >= msgsAmount {
+			slog.Warn("comment points to unknown message", "id", c.ID, "idx", c.MessageIdx)
+			continue
+		}
+		c.BaseURI = baseURI
+		grouped[c.MessageIdx].Comments = append(grouped[c.MessageIdx].Comments, c)
+	}
+	return grouped
+}
+
+func (h ChatHandler) postComment(w http.ResponseWriter, r *http.Request) {
+	// Validate data
+	chatID, err := deserID(w, r)
+	if err != nil {
+		return
+	}
+	var errs []error
+	branchID := mainBranchID
+	if raw := r.FormValue("branchId"); raw != "" {
+		branchID, err = uuid.Parse(raw)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	msgIdx, err := strconv.Atoi(r.FormValue("messageIdx"))
+	if err != nil {
+		errs = append(errs, err)
+	}
+	text, err := deserCommentText(r)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		http.Error(w, errors.Join(errs...).Error(), http.StatusBadRequest)
+		return
+	}
+
+	q, err := h.getQueries(w, r)
+	if err != nil {
+		return
+	}
+
+	// Check that commented message exists
+	var msgs []Message
+	if branchID == mainBranchID {
+		chat, err := findChat(r.Context(), q, chatID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		msgs = chat.Messages
+	} else {
+		branch, err := findChatBranch(r.Context(), q, chatID, branchID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		msgs = branch.Messages
+	}
+	if msgIdx < 0 || msgIdx >= len(msgs) {
+		http.Error(w, "Commented message doesn't exist", http.StatusBadRequest)
+		return
+	}
+
+	// Persist comment
+	comment := Comment{
+		ID:         uuid.New().String(),
+		ChatID:     chatID.String(),
+		MessageIdx: msgIdx,
+		Text:       text,
+		BaseURI:    h.baseURI,
+	}
+	err = q.SaveComment(r.Context(), db.SaveCommentParams{
+		ID:         comment.ID,
+		ChatID:     comment.ChatID,
+		BranchID:   branchID.String(),
+		MessageIdx: int64(msgIdx),
+		Text:       text,
+	})
+	if err != nil {
+		slog.Error("failed to save comment", "with", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Render new comment
+	if err := h.templates.Render(w, "comment", comment); err != nil {
+		slog.Error("failed to render comment", "with", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (h ChatHandler) putComment(w http.ResponseWriter, r *http.Request) {
+	// Validate data
+	chatID, err := deserID(w, r)
+	if err != nil {
+		return
+	}
+	commentID, err := deserCommentID(w, r)
+	if err != nil {
+		return
+	}
+	text, err := deserCommentText(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	q, err := h.getQueries(w, r)
+	if err != nil {
+		return
+	}
+
+	// Update comment
+	err = q.UpdateCommentText(r.Context(), db.UpdateCommentTextParams{
+		Text:   text,
+		ChatID: chatID.String(),
+		ID:     commentID.String(),
+	})
+	if err != nil {
+		slog.Error("failed to update comment", "with", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.renderComment(w, r, q, chatID, commentID)
+}
+
+func (h ChatHandler) postCommentResolve(w http.ResponseWriter, r *http.Request) {
+	h.setCommentResolved(w, r, true)
+}
+
+func (h ChatHandler) deleteCommentResolve(w http.ResponseWriter, r *http.Request) {
+	h.setCommentResolved(w, r, false)
+}
+
+func (h ChatHandler) setCommentResolved(w http.ResponseWriter, r *http.Request, resolved bool) {
+	// Validate data
+	chatID, err := deserID(w, r)
+	if err != nil {
+		return
+	}
+	commentID, err := deserCommentID(w, r)
+	if err != nil {
+		return
+	}
+
+	q, err := h.getQueries(w, r)
+	if err != nil {
+		return
+	}
+
+	// Update comment
+	err = q.UpdateCommentResolved(r.Context(), db.UpdateCommentResolvedParams{
+		Resolved: resolved,
+		ChatID:   chatID.String(),
+		ID:       commentID.String(),
+	})
+	if err != nil {
+		slog.Error("failed to update comment resolution", "with", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.renderComment(w, r, q, chatID, commentID)
+}
+
+func (h ChatHandler) deleteComment(w http.ResponseWriter, r *http.Request) {
+	// Validate data
+	chatID, err := deserID(w, r)
+	if err != nil {
+		return
+	}
+	commentID, err := deserCommentID(w, r)
+	if err != nil {
+		return
+	}
+
+	q, err := h.getQueries(w, r)
+	if err != nil {
+		return
+	}
+
+	// Delete comment
+	err = q.DeleteComment(r.Context(), db.DeleteCommentParams{
+		ChatID: chatID.String(),
+		ID:     commentID.String(),
+	})
+	if err != nil {
+		slog.Error("failed to delete comment", "id", commentID, "with", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h ChatHandler) renderComment(w http.ResponseWriter, r *http.Request, q *db.Queries, chatID, commentID uuid.UUID) {
+	row, err := q.FindComment(r.Context(), db.FindCommentParams{
+		ChatID: chatID.String(),
+		ID:     commentID.String(),
+	})
+	switch err {
+	case nil:
+		break
+	case sql.ErrNoRows:
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	default:
+		slog.Error("failed to find comment", "with", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = h.templates.Render(w, "comment", Comment{
+		ID:         row.ID,
+		ChatID:     chatID.String(),
+		MessageIdx: int(row.MessageIdx),
+		Text:       row.Text,
+		Resolved:   row.Resolved,
+		BaseURI:    h.baseURI,
+	})
+	if err != nil {
+		slog.Error("failed to render comment", "with", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func deserCommentID(w http.ResponseWriter, r *http.Request) (id uuid.UUID, err error) {
+	id, err = uuid.Parse(r.PathValue("commentId"))
+	if err != nil {
+		slog.Error("failed to parse comment", "id", id, "with", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+	return
+}
+
+func deserCommentText(r *http.Request) (string, error) {
+	text := strings.TrimSpace(r.FormValue("text"))
+	if text == "" {
+		return "", fmt.Errorf("comment can not be empty")
+	}
+	if len(text) > 2000 {
+		return "", fmt.Errorf("comment should not be larger than 2000 chars")
+	}
+	return text, nil
+}
@@ -0,0 +1,357 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"unicode"
+
+	"github.com/google/uuid"
+)
+
+type diffOp int
+
+const (
+	diffEqual diffOp = iota
+	diffDelete
+	diffInsert
+)
+
+type diffEdit struct {
+	Op diffOp
+	// Index in the left sequence, -1 for insertions
+	A int
+	// Index in the right sequence, -1 for deletions
+	B int
+}
+
+// Larger LCS tables are not computed, sequences are considered fully replaced
+const maxDiffCells = 1 << 22
+
+// Computes edit script from a to b using longest common subsequence
+func diffSeq[T comparable](a, b []T) []diffEdit {
+	var edits []diffEdit
+
+	// Common prefix & suffix don't need the table
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		edits = append(edits, diffEdit{Op: diffEqual, A: prefix, B: prefix})
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	ma, mb := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+
+	if len(ma)*len(mb) > maxDiffCells {
+		for i := range ma {
+			edits = append(edits, diffEdit{Op: diffDelete, A: prefix + i, B: -1})
+		}
+		for j := range mb {
+			edits = append(edits, diffEdit{Op: diffInsert, A: -1, B: prefix + j})
+		}
+	} else {
+		// lcs[i][j] is LCS length of ma[i:] & mb[j:]
+		w := len(mb) + 1
+		lcs := make([]int32, (len(ma)+1)*w)
+		for i := len(ma) - 1; i >= 0; i-- {
+			for j := len(mb) - 1; j >= 0; j-- {
+				if ma[i] == mb[j] {
+					lcs[i*w+j] = lcs[(i+1)*w+j+1] + 1
+				} else {
+					lcs[i*w+j] = max(lcs[(i+1)*w+j], lcs[i*w+j+1])
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < len(ma) || j < len(mb) {
+			switch {
+			case i < len(ma) && j < len(mb) && ma[i] == mb[j]:
+				edits = append(edits, diffEdit{Op: diffEqual, A: prefix + i, B: prefix + j})
+				i++
+				j++
+			case j < len(mb) && (i == len(ma) || lcs[i*w+j+1] >= lcs[(i+1)*w+j]):
+				edits = append(edits, diffEdit{Op: diffInsert, A: -1, B: prefix + j})
+				j++
+			default:
+				edits = append(edits, diffEdit{Op: diffDelete, A: prefix + i, B: -1})
+				i++
+			}
+		}
+	}
+
+	for k := suffix; k > 0; k-- {
+		edits = append(edits, diffEdit{Op: diffEqual, A: len(a) - k, B: len(b) - k})
+	}
+	return edits
+}
+
+// Splits text into words & whitespace runs, so joined tokens give the text back
+func tokenizeWords(s string) []string {
+	var tokens []string
+	start := 0
+	prevSpace := false
+	for i, r := range s {
+		space := unicode.IsSpace(r)
+		if i > start && space != prevSpace {
+			tokens = append(tokens, s[start:i])
+			start = i
+		}
+		prevSpace = space
+	}
+	if start < len(s) {
+		tokens = append(tokens, s[start:])
+	}
+	return tokens
+}
+
+type diffSpan struct {
+	Text    string
+	Changed bool
+}
+
+// Word level diff of two texts, changed spans are marked on their own side
+func diffWords(a, b string) (left, right []diffSpan) {
+	ta, tb := tokenizeWords(a), tokenizeWords(b)
+	add := func(spans []diffSpan, text string, changed bool) []diffSpan {
+		if n := len(spans); n > 0 && spans[n-1].Changed == changed {
+			spans[n-1].Text += text
+			return spans
+		}
+		return append(spans, diffSpan{Text: text, Changed: changed})
+	}
+	for _, e := range diffSeq(ta, tb) {
+		switch e.Op {
+		case diffEqual:
+			left = add(left, ta[e.A], false)
+			right = add(right, tb[e.B], false)
+		case diffDelete:
+			left = add(left, ta[e.A], true)
+		case diffInsert:
+			right = add(right, tb[e.B], true)
+		}
+	}
+	return
+}
+
+type diffRowKind string
+
+const (
+	diffRowSame    diffRowKind = "same"
+	diffRowChanged diffRowKind = "changed"
+	diffRowLeft    diffRowKind = "left"
+	diffRowRight   diffRowKind = "right"
+)
+
+type diffMessage struct {
+	// Index in the side history
+	Idx   int
+	Role  string
+	Spans []diffSpan
+}
+
+type diffRow struct {
+	Kind  diffRowKind
+	Left  *diffMessage
+	Right *diffMessage
+}
+
+// Aligns two histories after their common origin. Messages missing on the
+// other side are paired with the same role messages as changed, the rest
+// are unique to their side
+func alignMessages(left, right []Message) (origin int, rows []diffRow) {
+	for origin < len(left) && origin < len(right) && left[origin].key() == right[origin].key() {
+		origin++
+	}
+	plain := func(idx int, msg Message) *diffMessage {
+		return &diffMessage{Idx: idx, Role: msg.Role, Spans: []diffSpan{{Text: msg.Text}}}
+	}
+
+	var dels, ins []int
+	flush := func() {
+		i, j := 0, 0
+		for i < len(dels) || j < len(ins) {
+			switch {
+			case i < len(dels) && j < len(ins) && left[dels[i]].Role == right[ins[j]].Role:
+				l, r := diffWords(left[dels[i]].Text, right[ins[j]].Text)
+				rows = append(rows, diffRow{
+					Kind:  diffRowChanged,
+					Left:  &diffMessage{Idx: dels[i], Role: left[dels[i]].Role, Spans: l},
+					Right: &diffMessage{Idx: ins[j], Role: right[ins[j]].Role, Spans: r},
+				})
+				i++
+				j++
+			case i < len(dels):
+				rows = append(rows, diffRow{Kind: diffRowLeft, Left: plain(dels[i], left[dels[i]])})
+				i++
+			default:
+				rows = append(rows, diffRow{Kind: diffRowRight, Right: plain(ins[j], right[ins[j]])})
+				j++
+			}
+		}
+		dels, ins = dels[:0], ins[:0]
+	}
+
+	for _, e := range diffSeq(messageKeys(left[origin:]), messageKeys(right[origin:])) {
+		switch e.Op {
+		case diffEqual:
+			flush()
+			rows = append(rows, diffRow{
+				Kind:  diffRowSame,
+				Left:  plain(origin+e.A, left[origin+e.A]),
+				Right: plain(origin+e.B, right[origin+e.B]),
+			})
+		case diffDelete:
+			dels = append(dels, origin+e.A)
+		case diffInsert:
+			ins = append(ins, origin+e.B)
+		}
+	}
+	flush()
+	return
+}
+
+type diffSide struct {
+	ChatID    string
+	ID        string
+	Name      string
+	Main      bool
+	Mergeable bool
+	// Amount of messages unique to the side
+	Unique  int
+	BaseURI string
+}
+
+type diffView struct {
+	ChatID    string
+	ChatTitle string
+	Left      diffSide
+	Right     diffSide
+	Branches  []branchOption
+	Origin    int
+	Rows      []diffRow
+	BaseURI   string
+}
+
+func (h ChatHandler) getDiff(w http.ResponseWriter, r *http.Request) {
+	// Validate data
+	chatID, err := deserID(w, r)
+	var errs []error
+	if err != nil {
+		errs = append(errs, err)
+	}
+	leftID, err := deserDiffSide(r, "left")
+	if err != nil {
+		errs = append(errs, err)
+	}
+	rightID, err := deserDiffSide(r, "right")
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		http.Error(w, errors.Join(errs...).Error(), http.StatusBadRequest)
+		return
+	}
+
+	q, err := h.getQueries(w, r)
+	if err != nil {
+		return
+	}
+
+	chat, err := findChat(r.Context(), q, chatID)
+	if err != nil {
+		slog.Error("failed to find chat", "err", err.Error())
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	log, err := findChatLog(r.Context(), q, chatID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	branches, err := findChatBranches(r.Context(), q, chatID, log)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Resolve compared sides
+	options := []branchOption{{ID: mainBranchID.String(), Name: "Main"}}
+	findSide := func(id uuid.UUID) (Branch, diffSide, bool) {
+		if id == mainBranchID {
+			return Branch{ID: mainBranchID}, diffSide{
+				ChatID:  chatID.String(),
+				ID:      id.String(),
+				Name:    "Main",
+				Main:    true,
+				BaseURI: h.baseURI,
+			}, true
+		}
+		for _, b := range branches {
+			if b.ID == id {
+				generating := h.generating(r.Context(), q, chatID, b.ID)
+				return b, diffSide{
+					ChatID:    chatID.String(),
+					ID:        id.String(),
+					Name:      b.DisplayName(),
+					Mergeable: !generating && b.Mergeable(),
+					BaseURI:   h.baseURI,
+				}, true
+			}
+		}
+		return Branch{}, diffSide{}, false
+	}
+	for _, b := range branches {
+		options = append(options, branchOption{ID: b.ID.String(), Name: b.DisplayName()})
+	}
+	leftBranch, left, ok := findSide(leftID)
+	if !ok {
+		http.Error(w, fmt.Sprintf("Branch %s doesn't exist", leftID), http.StatusNotFound)
+		return
+	}
+	rightBranch, right, ok := findSide(rightID)
+	if !ok {
+		http.Error(w, fmt.Sprintf("Branch %s doesn't exist", rightID), http.StatusNotFound)
+		return
+	}
+
+	// Align messages
+	origin, rows := alignMessages(
+		branchHistory(chat, leftBranch),
+		branchHistory(chat, rightBranch),
+	)
+	for _, row := range rows {
+		switch row.Kind {
+		case diffRowLeft:
+			left.Unique++
+		case diffRowRight:
+			right.Unique++
+		}
+	}
+
+	err = h.templates.Render(w, "diff", diffView{
+		ChatID:    chatID.String(),
+		ChatTitle: chat.Title,
+		Left:      left,
+		Right:     right,
+		Branches:  options,
+		Origin:    origin,
+		Rows:      rows,
+		BaseURI:   h.baseURI,
+	})
+	if err != nil {
+		slog.Error("failed to render diff", "with", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Missing side is compared as main
+func deserDiffSide(r *http.Request, key string) (uuid.UUID, error) {
+	id, err := parseBranchRef(r.URL.Query().Get(key))
+	if err != nil {
+		return id, fmt.Errorf("failed to parse %s branch with %w", key, err)
+	}
+	return id, nil
+}
@@ -0,0 +1,453 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+
+	"shellshift/internal/db"
+	"shellshift/internal/ulid"
+	"shellshift/web/features/auth"
+)
+
+// Payload of the chat log entry
+type ChatLogger interface {
+	getActionName() string
+}
+
+// Converts payload of the previous version to the next one
+type eventUpgrade func(enc []byte) ([]byte, error)
+
+type eventType struct {
+	version  int
+	upgrades []eventUpgrade
+	decode   func(enc []byte) (ChatLogger, error)
+}
+
+// Registered event types by their action names
+var eventTypes = map[string]eventType{}
+
+// Registers event type, its version is bumped with every upgrade, so new
+// fields of the payload are filled for entries written before them
+func registerEvent[T ChatLogger](upgrades ...eventUpgrade) {
+	var zero T
+	action := zero.getActionName()
+	if _, ok := eventTypes[action]; ok {
+		panic(fmt.Sprintf("event %s is already registered", action))
+	}
+	eventTypes[action] = eventType{
+		version:  len(upgrades) + 1,
+		upgrades: upgrades,
+		decode: func(enc []byte) (ChatLogger, error) {
+			var e T
+			if len(enc) == 0 {
+				return e, nil
+			}
+			err := json.Unmarshal(enc, &e)
+			return e, err
+		},
+	}
+}
+
+func init() {
+	registerEvent[LogBranchCreated]()
+	registerEvent[LogBranchMerged]()
+	registerEvent[LogBranchRenamed]()
+	registerEvent[LogBranchDescribed]()
+	registerEvent[LogBranchAbandoned]()
+	registerEvent[LogBranchReopened]()
+	registerEvent[LogMessagesCherryPicked]()
+	registerEvent[LogBranchRebased]()
+	registerEvent[LogBranchMergeReverted]()
+	registerEvent[LogChatSplit]()
+	registerEvent[LogChatSplitFrom]()
+	registerEvent[LogChatsCombined]()
+	registerEvent[LogMessagesAppended]()
+	registerEvent[LogMessagesRemoved]()
+	registerEvent[LogMessagesReplaced]()
+	registerEvent[LogMessagesUpdated]()
+}
+
+type LogBranchCreated struct {
+	BranchID         string
+	OriginMessageIdx int
+}
+
+func (l LogBranchCreated) getActionName() string {
+	return "branch-created"
+}
+
+type LogBranchMerged struct {
+	// Empty for merges logged before reverts were introduced
+	MergeID            string
+	BranchID           string
+	MergedAtMessageIdX int
+	MergedAmount       int
+	// Indexes of merged branch messages
+	MessageIdxs []int
+	// Messages were merged as the single summary
+	Squashed bool
+}
+
+func (l LogBranchMerged) getActionName() string {
+	return "branch-merged"
+}
+
+type LogBranchMergeReverted struct {
+	MergeID  string
+	BranchID string
+	// Index of the first removed main message at the moment of revert
+	MessageIdx int
+	Amount     int
+}
+
+func (l LogBranchMergeReverted) getActionName() string {
+	return "branch-merge-reverted"
+}
+
+type LogBranchRenamed struct {
+	BranchID string
+	Name     string
+	// Name was generated by LLM
+	Generated bool
+}
+
+func (l LogBranchRenamed) getActionName() string {
+	return "branch-renamed"
+}
+
+type LogBranchDescribed struct {
+	BranchID    string
+	Description string
+}
+
+func (l LogBranchDescribed) getActionName() string {
+	return "branch-described"
+}
+
+type LogBranchAbandoned struct {
+	BranchID string
+}
+
+func (l LogBranchAbandoned) getActionName() string {
+	return "branch-abandoned"
+}
+
+type LogBranchReopened struct {
+	BranchID string
+}
+
+func (l LogBranchReopened) getActionName() string {
+	return "branch-reopened"
+}
+
+type LogBranchRebased struct {
+	BranchID         string
+	OriginMessageIdx int
+	// Set when branch prompts were replayed into the new branch
+	SourceBranchID string
+}
+
+func (l LogBranchRebased) getActionName() string {
+	return "branch-rebased"
+}
+
+// Logged in the chat which messages were split off
+type LogChatSplit struct {
+	NewChatID  string
+	MessageIdx int
+}
+
+func (l LogChatSplit) getActionName() string {
+	return "chat-split"
+}
+
+// Logged in the chat created by the split
+type LogChatSplitFrom struct {
+	SourceChatID string
+	MessageIdx   int
+}
+
+func (l LogChatSplitFrom) getActionName() string {
+	return "chat-split-from"
+}
+
+// Logged in both combined chats
+type LogChatsCombined struct {
+	SourceChatID string
+	TargetChatID string
+	Mode         combineMode
+}
+
+func (l LogChatsCombined) getActionName() string {
+	return "chats-combined"
+}
+
+type LogMessagesCherryPicked struct {
+	SourceBranchID string
+	TargetBranchID string
+	// Indexes of picked source branch messages
+	MessageIdxs []int
+}
+
+func (l LogMessagesCherryPicked) getActionName() string {
+	return "messages-cherry-picked"
+}
+
+// Messages were added to the end of main or the branch
+type LogMessagesAppended struct {
+	BranchID string
+	Messages []Message
+}
+
+func (l LogMessagesAppended) getActionName() string {
+	return "messages-appended"
+}
+
+type LogMessagesRemoved struct {
+	BranchID   string
+	MessageIdx int
+	Amount     int
+}
+
+func (l LogMessagesRemoved) getActionName() string {
+	return "messages-removed"
+}
+
+// Messages were changed in place starting from the index, such as the
+// checkpointed answer when it's finished
+type LogMessagesUpdated struct {
+	BranchID   string
+	MessageIdx int
+	Messages   []Message
+}
+
+func (l LogMessagesUpdated) getActionName() string {
+	return "messages-updated"
+}
+
+// Snapshot of all messages, logged when the change isn't an append, removal
+// or update in place
+type LogMessagesReplaced struct {
+	BranchID string
+	Messages []Message
+}
+
+func (l LogMessagesReplaced) getActionName() string {
+	return "messages-replaced"
+}
+
+// Actor of the logged change, background jobs act as the system
+func logActor(ctx context.Context) string {
+	if userID, ok := ctx.Value(auth.UserIDKey).(string); ok && userID != "" {
+		return userID
+	}
+	return "system"
+}
+
+func saveChatLog[T ChatLogger](ctx context.Context, q *db.Queries, chatID uuid.UUID, entry T) error {
+	action := entry.getActionName()
+	event, ok := eventTypes[action]
+	if !ok {
+		return fmt.Errorf("event %s is not registered", action)
+	}
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s with %w", action, err)
+	}
+	err = q.SaveChatLog(ctx, db.SaveChatLogParams{
+		ID:      ulid.New(),
+		ChatID:  chatID.String(),
+		Action:  action,
+		Version: int64(event.version),
+		Actor:   logActor(ctx),
+		Meta:    encoded,
+	})
+	if err != nil {
+		slog.Error("failed to save chat log", "action", action, "with", err)
+		return err
+	}
+	afterCommit(ctx, func() { publishLogEntry(ctx, chatID, entry) })
+	return nil
+}
+
+type LogEntry struct {
+	ID      string
+	Action  string
+	Version int
+	// User who made the change or "system", empty for old entries
+	Actor string
+	// Zero for entries logged before timestamps were stored
+	CreatedAt time.Time
+	Meta      ChatLogger
+}
+
+func findChatLog(ctx context.Context, q *db.Queries, chatID uuid.UUID) (log []LogEntry, _ error) {
+	rows, err := q.FindChatLog(ctx, chatID.String())
+	if err != nil {
+		return log, fmt.Errorf("failed to find chat log with %w", err)
+	}
+
+	var errs []error
+	for _, row := range rows {
+		event, ok := eventTypes[row.Action]
+		if !ok {
+			errs = append(errs, fmt.Errorf("unknown log action %s", row.Action))
+			continue
+		}
+		meta, err := decodeEvent(event, int(row.Version), row.Meta)
+		if err != nil {
+			slog.Error("failed to decode log entry", "id", row.ID, "action", row.Action, "with", err)
+			errs = append(errs, err)
+			continue
+		}
+		var createdAt time.Time
+		if row.CreatedAt > 0 {
+			createdAt = time.Unix(row.CreatedAt, 0)
+		}
+		log = append(log, LogEntry{
+			ID:        row.ID,
+			Action:    row.Action,
+			Version:   event.version,
+			Actor:     row.Actor,
+			CreatedAt: createdAt,
+			Meta:      meta,
+		})
+	}
+
+	if len(errs) > 0 {
+		return log, fmt.Errorf("failed to deserialize logs with %w", errors.Join(errs...))
+	}
+	return log, nil
+}
+
+// Upgrades payload to the current version of the event & decodes it
+func decodeEvent(event eventType, version int, enc []byte) (ChatLogger, error) {
+	if version < 1 || version > event.version {
+		return nil, fmt.Errorf("unsupported event version %d", version)
+	}
+	for _, upgrade := range event.upgrades[version-1:] {
+		var err error
+		enc, err = upgrade(enc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to upgrade event from version %d with %w", version, err)
+		}
+		version++
+	}
+	return event.decode(enc)
+}
+
+// Logs the smallest event which turns prev messages into next ones
+func logMessagesChange(ctx context.Context, q *db.Queries, chatID, branchID uuid.UUID, prev, next []Message) error {
+	prefix := 0
+	for prefix < min(len(prev), len(next)) && prev[prefix] == next[prefix] {
+		prefix++
+	}
+	switch {
+	case prefix == len(prev) && prefix == len(next):
+		return nil
+	case prefix == len(prev):
+		return saveChatLog(ctx, q, chatID, LogMessagesAppended{
+			BranchID: branchID.String(),
+			Messages: next[prefix:],
+		})
+	case len(next) < len(prev) && slices.Equal(prev[len(prev)-len(next)+prefix:], next[prefix:]):
+		return saveChatLog(ctx, q, chatID, LogMessagesRemoved{
+			BranchID:   branchID.String(),
+			MessageIdx: prefix,
+			Amount:     len(prev) - len(next),
+		})
+	case len(next) == len(prev):
+		end := len(next)
+		for prev[end-1] == next[end-1] {
+			end--
+		}
+		return saveChatLog(ctx, q, chatID, LogMessagesUpdated{
+			BranchID:   branchID.String(),
+			MessageIdx: prefix,
+			Messages:   next[prefix:end],
+		})
+	default:
+		return saveChatLog(ctx, q, chatID, LogMessagesReplaced{
+			BranchID: branchID.String(),
+			Messages: next,
+		})
+	}
+}
+
+// State of the chat rebuilt from its log
+type chatReplay struct {
+	Messages []Message
+	// Branches in the order of their creation, including trashed ones
+	Branches []Branch
+}
+
+// Replays logged events, the log can be cut to see the chat in the past
+func replayChat(log []LogEntry) chatReplay {
+	var replay chatReplay
+	branches := map[string]*Branch{}
+	var order []string
+	branch := func(id string) *Branch {
+		if b, ok := branches[id]; ok {
+			return b
+		}
+		parsed, _ := uuid.Parse(id)
+		b := &Branch{ID: parsed, State: BranchActive, Origin: -1}
+		branches[id] = b
+		order = append(order, id)
+		return b
+	}
+	messages := func(id string, at time.Time) *[]Message {
+		if id == mainBranchID.String() {
+			return &replay.Messages
+		}
+		b := branch(id)
+		if !at.IsZero() {
+			b.UpdatedAt = at
+		}
+		return &b.Messages
+	}
+
+	for _, entry := range log {
+		switch m := entry.Meta.(type) {
+		case LogBranchCreated:
+			branch(m.BranchID)
+		case LogBranchRenamed:
+			branch(m.BranchID).Name = m.Name
+		case LogBranchDescribed:
+			branch(m.BranchID).Description = m.Description
+		case LogBranchAbandoned:
+			branch(m.BranchID).Abandoned = true
+		case LogBranchReopened:
+			branch(m.BranchID).Abandoned = false
+		case LogMessagesAppended:
+			msgs := messages(m.BranchID, entry.CreatedAt)
+			*msgs = slices.Concat(*msgs, m.Messages)
+		case LogMessagesRemoved:
+			msgs := messages(m.BranchID, entry.CreatedAt)
+			start := min(max(m.MessageIdx, 0), len(*msgs))
+			*msgs = slices.Delete(*msgs, start, min(start+m.Amount, len(*msgs)))
+		case LogMessagesUpdated:
+			msgs := messages(m.BranchID, entry.CreatedAt)
+			start := min(max(m.MessageIdx, 0), len(*msgs))
+			*msgs = slices.Replace(*msgs, start, min(start+len(m.Messages), len(*msgs)), m.Messages...)
+		case LogMessagesReplaced:
+			msgs := messages(m.BranchID, entry.CreatedAt)
+			*msgs = slices.Clone(m.Messages)
+		}
+	}
+
+	for _, id := range order {
+		b := branches[id]
+		b.Origin = branchOrigin(log, b.ID)
+		b.State = branchState(log, *b)
+		replay.Branches = append(replay.Branches, *b)
+	}
+	return replay
+}
@@ -0,0 +1,87 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+
+	"shellshift/internal/sse"
+	"shellshift/web/features/auth"
+	"shellshift/web/features/chat/feed"
+)
+
+const (
+	feedChatCreated    = "chat-created"
+	feedTitleGenerated = "title-generated"
+	feedTagsChanged    = "tags-changed"
+	feedBranchCreated  = "branch-created"
+	feedBranchMerged   = "branch-merged"
+	feedChatDeleted    = "chat-deleted"
+)
+
+// Change published to all open pages of the user
+type feedEvent struct {
+	Type     string `json:"type"`
+	ChatID   string `json:"chatId"`
+	BranchID string `json:"branchId,omitempty"`
+	Title    string `json:"title,omitempty"`
+}
+
+var userFeed = feed.New()
+
+// Publishes event to the user of ctx, changes made without user aren't
+// published
+func publishFeed(ctx context.Context, e feedEvent) {
+	userID, ok := ctx.Value(auth.UserIDKey).(string)
+	if !ok || userID == "" {
+		return
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		slog.Error("failed to encode feed event", "type", e.Type, "with", err)
+		return
+	}
+	userFeed.Publish(userID, feed.Event{Type: e.Type, Data: string(data)})
+}
+
+// Publishes chat log entries which change the chat outside of its messages
+func publishLogEntry(ctx context.Context, chatID uuid.UUID, entry ChatLogger) {
+	switch e := entry.(type) {
+	case LogBranchCreated:
+		publishFeed(ctx, feedEvent{Type: feedBranchCreated, ChatID: chatID.String(), BranchID: e.BranchID})
+	case LogBranchMerged:
+		publishFeed(ctx, feedEvent{Type: feedBranchMerged, ChatID: chatID.String(), BranchID: e.BranchID})
+	}
+}
+
+// Streams feed events of the current user
+func (h ChatHandler) getFeed(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value(auth.UserIDKey).(string)
+	events := sse.NewWriter(w, r)
+
+	heartbeat := time.NewTicker(sse.HeartbeatInterval)
+	defer heartbeat.Stop()
+
+	sub := userFeed.Subscribe(r.Context(), userID)
+	for {
+		select {
+		case e, ok := <-sub:
+			if !ok {
+				return
+			}
+			if err := events.Send(sse.Event{Type: e.Type, Data: e.Data}); err != nil {
+				slog.Error("failed to send feed event", "with", err)
+				return
+			}
+		case <-heartbeat.C:
+			if err := events.Heartbeat(); err != nil {
+				slog.Error("failed to send heartbeat", "with", err)
+				return
+			}
+		}
+	}
+}
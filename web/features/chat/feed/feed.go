@@ -0,0 +1,67 @@
+package feed
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+)
+
+// Amount of events kept for the subscriber which doesn't read them yet
+const backlog = 32
+
+type Event struct {
+	Type string
+	Data string
+}
+
+// Delivers events to every open connection of the same user
+type Feed struct {
+	l    sync.Mutex
+	subs map[string]map[chan Event]struct{}
+}
+
+func New() *Feed {
+	return &Feed{
+		subs: make(map[string]map[chan Event]struct{}),
+	}
+}
+
+// Returns channel with events published to the user after the subscription,
+// it's closed when ctx ends
+func (f *Feed) Subscribe(ctx context.Context, userID string) <-chan Event {
+	c := make(chan Event, backlog)
+
+	f.l.Lock()
+	if f.subs[userID] == nil {
+		f.subs[userID] = make(map[chan Event]struct{})
+	}
+	f.subs[userID][c] = struct{}{}
+	f.l.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		f.l.Lock()
+		defer f.l.Unlock()
+		delete(f.subs[userID], c)
+		if len(f.subs[userID]) == 0 {
+			delete(f.subs, userID)
+		}
+		close(c)
+	}()
+	return c
+}
+
+// Sends event to all subscriptions of the user without waiting for them,
+// slow subscribers miss the event
+func (f *Feed) Publish(userID string, e Event) {
+	f.l.Lock()
+	defer f.l.Unlock()
+	for c := range f.subs[userID] {
+		select {
+		case c <- e:
+		default:
+			slog.Warn("feed event was dropped", "type", e.Type)
+		}
+	}
+}
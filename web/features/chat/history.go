@@ -0,0 +1,268 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+
+	"shellshift/internal/db"
+)
+
+var errUnknownEvent = errors.New("event doesn't exist in the chat log")
+
+// Cuts the log after the event with the id, RFC3339 or unix timestamp
+func cutLog(log []LogEntry, at string) ([]LogEntry, error) {
+	if i := slices.IndexFunc(log, func(e LogEntry) bool { return e.ID == at }); i != -1 {
+		return log[:i+1], nil
+	}
+	t, err := time.Parse(time.RFC3339, at)
+	if err != nil {
+		sec, convErr := strconv.ParseInt(at, 10, 64)
+		if convErr != nil {
+			return nil, errUnknownEvent
+		}
+		t = time.Unix(sec, 0)
+	}
+	// Entries without timestamps are older than any of the stored ones
+	end := 0
+	for i, e := range log {
+		if e.CreatedAt.After(t) {
+			break
+		}
+		end = i + 1
+	}
+	return log[:end], nil
+}
+
+type historyEvent struct {
+	ID        string
+	Action    string
+	Actor     string
+	CreatedAt time.Time
+	Current   bool
+}
+
+type historyView struct {
+	ChatID    string
+	ChatTitle string
+	// Last applied event
+	At       string
+	AtTime   time.Time
+	BranchID string
+	Main     bool
+	Messages []HTMLMessage
+	Branches []branchOption
+	Events   []historyEvent
+	BaseURI  string
+}
+
+// Read-only view of the chat as it was after the event
+func (h ChatHandler) getChatAt(w http.ResponseWriter, r *http.Request) {
+	// Validate data
+	chatID, err := deserID(w, r)
+	var errs []error
+	if err != nil {
+		errs = append(errs, err)
+	}
+	branchID, _, err := deserBranchID(w, r)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		http.Error(w, errors.Join(errs...).Error(), http.StatusBadRequest)
+		return
+	}
+
+	q, err := h.getQueries(w, r)
+	if err != nil {
+		return
+	}
+
+	chat, err := findChat(r.Context(), q, chatID)
+	if err != nil {
+		slog.Error("failed to find chat", "err", err.Error())
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	log, err := findChatLog(r.Context(), q, chatID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	past, err := cutLog(log, r.URL.Query().Get("at"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	replay := replayChat(past)
+
+	view := historyView{
+		ChatID:    chatID.String(),
+		ChatTitle: chat.Title,
+		BranchID:  mainBranchID.String(),
+		Main:      true,
+		Branches:  []branchOption{{ID: mainBranchID.String(), Name: "Main"}},
+		BaseURI:   h.baseURI,
+	}
+	if len(past) > 0 {
+		view.At = past[len(past)-1].ID
+		view.AtTime = past[len(past)-1].CreatedAt
+	}
+	msgs := replay.Messages
+	for _, b := range replay.Branches {
+		if len(b.Messages) == 0 {
+			continue
+		}
+		view.Branches = append(view.Branches, branchOption{ID: b.ID.String(), Name: b.DisplayName()})
+		if b.ID == branchID {
+			view.BranchID, view.Main = b.ID.String(), false
+			msgs = branchHistory(Chat{Messages: replay.Messages}, b)
+		}
+	}
+	if branchID != mainBranchID && view.Main {
+		http.Error(w, "Branch didn't exist at that moment", http.StatusNotFound)
+		return
+	}
+	view.Messages = renderMessages(Chat{Messages: msgs})
+
+	// Newest events first, so the latest changes are at hand
+	for i := len(log) - 1; i >= 0; i-- {
+		view.Events = append(view.Events, historyEvent{
+			ID:        log[i].ID,
+			Action:    log[i].Action,
+			Actor:     log[i].Actor,
+			CreatedAt: log[i].CreatedAt,
+			Current:   log[i].ID == view.At,
+		})
+	}
+
+	err = h.templates.Render(w, "history", view)
+	if err != nil {
+		slog.Error("failed to render history page", "with", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Restores main or the branch as it was after the event into the new chat
+// or the new branch of the same chat
+func (h ChatHandler) postCheckout(w http.ResponseWriter, r *http.Request) {
+	// Validate data
+	chatID, err := deserID(w, r)
+	var errs []error
+	if err != nil {
+		errs = append(errs, err)
+	}
+	branchID, err := parseBranchRef(r.FormValue("branch"))
+	if err != nil {
+		errs = append(errs, err)
+	}
+	at := r.FormValue("at")
+	if at == "" {
+		errs = append(errs, errors.New("event should be specified"))
+	}
+	target := r.FormValue("target")
+	if target != "chat" && target != "branch" {
+		errs = append(errs, fmt.Errorf("unknown restore target %q", target))
+	}
+	if len(errs) > 0 {
+		http.Error(w, errors.Join(errs...).Error(), http.StatusBadRequest)
+		return
+	}
+
+	q, err := h.getQueries(w, r)
+	if err != nil {
+		return
+	}
+
+	chat, err := findChat(r.Context(), q, chatID)
+	if err != nil {
+		slog.Error("failed to find chat", "err", err.Error())
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	log, err := findChatLog(r.Context(), q, chatID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	past, err := cutLog(log, at)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	replay := replayChat(past)
+	msgs := replay.Messages
+	if branchID != mainBranchID {
+		i := slices.IndexFunc(replay.Branches, func(b Branch) bool { return b.ID == branchID })
+		if i == -1 || len(replay.Branches[i].Messages) == 0 {
+			http.Error(w, "Branch didn't exist at that moment", http.StatusNotFound)
+			return
+		}
+		msgs = branchHistory(Chat{Messages: replay.Messages}, replay.Branches[i])
+	}
+	if len(msgs) == 0 {
+		http.Error(w, "Nothing to restore at that moment", http.StatusBadRequest)
+		return
+	}
+
+	if target == "chat" {
+		restored := Chat{
+			ID:       uuid.New(),
+			Title:    chat.Title + " (restored)",
+			Messages: slices.Clone(msgs),
+		}
+		err = inTx(r.Context(), q, func(ctx context.Context, q *db.Queries) error {
+			if err := saveChat(ctx, q, restored); err != nil {
+				return err
+			}
+			err := q.CopyChatTags(ctx, db.CopyChatTagsParams{
+				TargetID: restored.ID.String(),
+				SourceID: chatID.String(),
+			})
+			if err == nil {
+				err = q.SaveMention(ctx, db.SaveMentionParams{
+					SourceID: restored.ID.String(),
+					TargetID: chatID.String(),
+				})
+			}
+			if err != nil {
+				slog.Error("failed to link restored chat", "with", err)
+			}
+			return err
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("HX-Redirect", fmt.Sprintf("%s/%s", h.baseURI, restored.ID))
+		return
+	}
+
+	// Branch is based on the part of the current main which is unchanged
+	common := 0
+	for common < min(len(msgs), len(chat.Messages)) && msgs[common].key() == chat.Messages[common].key() {
+		common++
+	}
+	if common == len(msgs) {
+		http.Error(w, "Main already contains these messages", http.StatusBadRequest)
+		return
+	}
+	restored := Branch{
+		ID:       uuid.New(),
+		Messages: slices.Clone(msgs[common:]),
+		Origin:   common - 1,
+	}
+	err = createBranch(r.Context(), q, chatID, &restored)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("HX-Redirect", fmt.Sprintf("%s/%s/branch/%s", h.baseURI, chatID, restored.ID))
+}
@@ -0,0 +1,71 @@
+package chat
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+
+	"shellshift/internal/db"
+)
+
+const (
+	// Repeated submissions are recognized for this long
+	idempotencyWindow       = time.Hour
+	maxIdempotencyKeyLength = 64
+)
+
+// Response of the post, which is given again for a repeated key
+type postResponse struct {
+	Redirect string
+	Body     []byte
+}
+
+func (p postResponse) write(w http.ResponseWriter) {
+	if p.Redirect != "" {
+		w.Header().Set("HX-Redirect", p.Redirect)
+		return
+	}
+	w.Write(p.Body)
+}
+
+// Finds response of the branch post with the key, ok is false when the key
+// wasn't used within the window
+func findPostResponse(ctx context.Context, q *db.Queries, chatID, branchID uuid.UUID, key string) (_ postResponse, ok bool, _ error) {
+	row, err := q.FindIdempotencyKey(ctx, db.FindIdempotencyKeyParams{
+		ChatID:    chatID.String(),
+		BranchID:  branchID.String(),
+		Key:       key,
+		CreatedAt: time.Now().Add(-idempotencyWindow).Unix(),
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return postResponse{}, false, nil
+	}
+	if err != nil {
+		return postResponse{}, false, fmt.Errorf("failed to find idempotency key with %w", err)
+	}
+	return postResponse{Redirect: row.Redirect, Body: row.Body}, true, nil
+}
+
+// Stores response of the branch post, should run in the transaction of the
+// post, so the key is saved only with its changes. Expired keys are dropped
+func savePostResponse(ctx context.Context, q *db.Queries, chatID, branchID uuid.UUID, key string, resp postResponse) error {
+	err := q.DeleteExpiredIdempotencyKeys(ctx, time.Now().Add(-idempotencyWindow).Unix())
+	if err == nil {
+		err = q.SaveIdempotencyKey(ctx, db.SaveIdempotencyKeyParams{
+			ChatID:   chatID.String(),
+			BranchID: branchID.String(),
+			Key:      key,
+			Redirect: resp.Redirect,
+			Body:     resp.Body,
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save idempotency key with %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,574 @@
+package chat
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+
+	"shellshift/internal/db"
+	"shellshift/internal/ulid"
+	"shellshift/web/features/auth"
+)
+
+const (
+	jobMessage    = "message"
+	jobTitle      = "title"
+	jobBranchName = "branch-name"
+
+	// Status of the job which ran out of attempts
+	jobFailed = "failed"
+)
+
+const (
+	// Jobs run concurrently per user, so long generations of one user
+	// don't hold answers & titles of the others
+	jobWorkersPerUser = 2
+	jobMaxAttempts    = 3
+	// Running job is claimed again when its worker stops extending the lock
+	jobLockTimeout  = 30 * time.Second
+	jobPollInterval = 2 * time.Second
+	// Delay before the first retry, doubled with every next one
+	jobRetryDelay = 5 * time.Second
+	// Interval of checks whether the awaited job has started
+	jobWaitInterval = time.Second
+)
+
+// Sent after the checkpointed answer, so the model finishes it
+const continuePrompt = "Continue your previous answer exactly from where it stopped. Don't repeat any of it."
+
+// Payload of the job answering the last user message of the branch
+type messageJob struct {
+	MentionIDs []string
+	// Prompts of the rebased branch which are still to be replayed, the
+	// first one is appended after the answer and queued as the next job
+	Replay []Message `json:",omitempty"`
+}
+
+// Payload of the job naming the new chat
+type titleJob struct {
+	Prompt string
+}
+
+// Payload of the job naming the new branch
+type branchNameJob struct {
+	Prompt string
+}
+
+// Saves job and wakes up the job runner. Failed jobs of the same target are
+// replaced by the new one
+func (h ChatHandler) enqueueJob(ctx context.Context, q *db.Queries, kind string, chatID uuid.UUID, branchID string, payload any) error {
+	enc, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s job with %w", kind, err)
+	}
+	err = q.DeleteFailedJobs(ctx, db.DeleteFailedJobsParams{
+		ChatID:   chatID.String(),
+		BranchID: branchID,
+		Kind:     kind,
+	})
+	if err == nil {
+		err = q.SaveJob(ctx, db.SaveJobParams{
+			ID:          ulid.New(),
+			Kind:        kind,
+			ChatID:      chatID.String(),
+			BranchID:    branchID,
+			Payload:     enc,
+			MaxAttempts: jobMaxAttempts,
+		})
+	}
+	if err != nil {
+		slog.Error("failed to enqueue job", "kind", kind, "with", err)
+		return err
+	}
+
+	// Worker wouldn't see the job before the commit
+	afterCommit(ctx, h.wakeJobs)
+	return nil
+}
+
+// Wakes up the job runner, unless it was already woken
+func (h ChatHandler) wakeJobs() {
+	select {
+	case h.jobWake <- struct{}{}:
+	default:
+	}
+}
+
+type jobView struct {
+	ChatID      string
+	BranchID    string
+	Status      string
+	Attempts    int
+	MaxAttempts int
+	// Time of the next attempt of the queued job
+	RunAt   time.Time
+	Error   string
+	BaseURI string
+}
+
+// Whether the job is going to produce its result
+func (j *jobView) Pending() bool {
+	return j != nil && j.Status != jobFailed
+}
+
+// Finds the latest job of the target, nil when there is no unfinished one
+func (h ChatHandler) findJob(ctx context.Context, q *db.Queries, kind string, chatID uuid.UUID, branchID string) (*jobView, error) {
+	row, err := q.FindLatestJob(ctx, db.FindLatestJobParams{
+		ChatID:   chatID.String(),
+		BranchID: branchID,
+		Kind:     kind,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find %s job with %w", kind, err)
+	}
+	return &jobView{
+		ChatID:      chatID.String(),
+		BranchID:    branchID,
+		Status:      row.Status,
+		Attempts:    int(row.Attempts),
+		MaxAttempts: int(row.MaxAttempts),
+		RunAt:       time.Unix(row.RunAt, 0).UTC(),
+		Error:       row.Error,
+		BaseURI:     h.baseURI,
+	}, nil
+}
+
+// Whether the branch message is being generated or is queued for generation
+func (h ChatHandler) generating(ctx context.Context, q *db.Queries, chatID, branchID uuid.UUID) bool {
+	if h.msgChan.Generating(ctx, branchID) {
+		return true
+	}
+	job, err := h.findJob(ctx, q, jobMessage, chatID, branchID.String())
+	if err != nil {
+		slog.Error("failed to find message job", "with", err)
+		return false
+	}
+	return job.Pending()
+}
+
+// Opens databases of all users, so jobs interrupted by the restart are
+// resumed or failed without waiting for their users to come back
+func (h ChatHandler) recoverJobs(ctx context.Context) {
+	if err := h.db.LoadAll(ctx); err != nil {
+		slog.Error("failed to load user databases", "with", err)
+	}
+	h.wakeJobs()
+}
+
+// Runs jobs of all users whose databases were accessed or loaded since the
+// start. Jobs of the stopped server are claimed again after their lock timeout
+func (h ChatHandler) runJobs(ctx context.Context) {
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+
+	// Running jobs by users
+	running := map[string]int{}
+	done := make(chan string)
+	for {
+		h.startJobs(ctx, running, done)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-h.jobWake:
+		case userID := <-done:
+			running[userID]--
+		}
+	}
+}
+
+// Claims jobs of every user up to the limit and runs them in background,
+// user of the finished job is sent to done
+func (h ChatHandler) startJobs(ctx context.Context, running map[string]int, done chan<- string) {
+	for userID, q := range h.db.All() {
+		for running[userID] < jobWorkersPerUser && ctx.Err() == nil {
+			job, err := q.ClaimJob(ctx, time.Now().Add(jobLockTimeout).Unix())
+			if errors.Is(err, sql.ErrNoRows) {
+				break
+			}
+			if err != nil {
+				slog.Error("failed to claim job", "user", userID, "with", err)
+				break
+			}
+			running[userID]++
+			go func() {
+				// Stream broker and feed belong to the user
+				h.runJob(context.WithValue(ctx, auth.UserIDKey, userID), q, job)
+				select {
+				case done <- userID:
+				case <-ctx.Done():
+				}
+			}()
+		}
+	}
+}
+
+func (h ChatHandler) runJob(ctx context.Context, q *db.Queries, job db.ClaimJobRow) {
+	slog.Info("running job", "id", job.ID, "kind", job.Kind, "attempt", job.Attempts)
+
+	// Lock of the last attempt has expired, so its worker was lost
+	if job.Attempts > job.MaxAttempts {
+		err := q.FailJob(ctx, db.FailJobParams{
+			Error: "server stopped while running the job",
+			ID:    job.ID,
+		})
+		if err != nil {
+			slog.Error("failed to fail job", "id", job.ID, "with", err)
+		}
+		return
+	}
+
+	lockCtx, unlock := context.WithCancel(ctx)
+	go keepJobLocked(lockCtx, q, job.ID)
+	var err error
+	switch job.Kind {
+	case jobMessage:
+		err = h.runMessageJob(ctx, q, job)
+	case jobTitle:
+		err = h.runTitleJob(ctx, q, job)
+	case jobBranchName:
+		err = h.runBranchNameJob(ctx, q, job)
+	default:
+		err = fmt.Errorf("unknown job kind %s", job.Kind)
+	}
+	unlock()
+
+	switch {
+	case err == nil:
+		err = q.DeleteJob(ctx, job.ID)
+	case job.Attempts >= job.MaxAttempts:
+		slog.Error("job failed", "id", job.ID, "kind", job.Kind, "with", err)
+		err = q.FailJob(ctx, db.FailJobParams{
+			Error: err.Error(),
+			ID:    job.ID,
+		})
+	default:
+		slog.Warn("job attempt failed", "id", job.ID, "kind", job.Kind, "attempt", job.Attempts, "with", err)
+		delay := jobRetryDelay << (job.Attempts - 1)
+		err = q.RetryJob(ctx, db.RetryJobParams{
+			RunAt: time.Now().Add(delay).Unix(),
+			Error: err.Error(),
+			ID:    job.ID,
+		})
+	}
+	if err != nil {
+		slog.Error("failed to update job", "id", job.ID, "with", err)
+	}
+}
+
+// Extends the job lock until ctx ends
+func keepJobLocked(ctx context.Context, q *db.Queries, id string) {
+	ticker := time.NewTicker(jobLockTimeout / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := q.ExtendJobLock(ctx, db.ExtendJobLockParams{
+				LockedUntil: time.Now().Add(jobLockTimeout).Unix(),
+				ID:          id,
+			})
+			if err != nil && ctx.Err() == nil {
+				slog.Error("failed to extend job lock", "id", id, "with", err)
+			}
+		}
+	}
+}
+
+func (h ChatHandler) runMessageJob(ctx context.Context, q *db.Queries, job db.ClaimJobRow) error {
+	var payload messageJob
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return fmt.Errorf("failed to decode job with %w", err)
+	}
+	chatID, err := uuid.Parse(job.ChatID)
+	if err != nil {
+		return err
+	}
+	branchID, err := uuid.Parse(job.BranchID)
+	if err != nil {
+		return err
+	}
+
+	chat, err := findChat(ctx, q, chatID)
+	if err != nil {
+		return fmt.Errorf("failed to find chat with %w", err)
+	}
+	branch, err := findChatBranch(ctx, q, chatID, branchID)
+	if err != nil {
+		return fmt.Errorf("failed to find branch with %w", err)
+	}
+	if len(branch.Messages) == 0 {
+		return nil
+	}
+	// Checkpointed answer is continued, finished one could be saved right
+	// before the previous worker was lost
+	last := branch.Messages[len(branch.Messages)-1]
+	continued := last.Role == "model" && last.Incomplete
+	if last.Role != "user" && !continued {
+		return nil
+	}
+	mentionedChats := make([]Chat, len(payload.MentionIDs))
+	for i, id := range payload.MentionIDs {
+		mentionID, err := uuid.Parse(id)
+		if err != nil {
+			return err
+		}
+		mentionedChats[i], err = findChat(ctx, q, mentionID)
+		if err != nil {
+			return fmt.Errorf("failed to find mentioned chat with %w", err)
+		}
+	}
+
+	stream := h.msgChan.Alloc(ctx, branch.ID)
+	defer h.msgChan.Free(stream)
+
+	history := branchHistory(chat, branch)
+	var prefix string
+	if continued {
+		// Model is asked to go on, its text is appended to the checkpoint
+		prefix = last.Text
+		history = append(history, Message{Role: "user", Text: continuePrompt})
+		branch.Messages = branch.Messages[:len(branch.Messages)-1]
+		stream.Chunks <- prefix
+	}
+	cp := &checkpointer{q: q, chatID: chat.ID, branch: &branch, saved: continued, payload: job.Payload}
+	msg, err := generateMessage(ctx, h.g,
+		history,
+		mentionedChats,
+		stream.Chunks,
+		func(ctx context.Context, text string) { cp.save(ctx, prefix+text) },
+	)
+	if err != nil {
+		return fmt.Errorf("failed to generate message with %w", err)
+	}
+	msg.Text = prefix + msg.Text
+
+	// Posts check the job under the same lock, so none of them lands
+	// between the answer & the next replayed prompt
+	unlock := h.branchLocks.Lock(branch.ID)
+	defer unlock()
+
+	// Answer is appended to the fresh branch when it was changed meanwhile
+	for attempt := 1; ; attempt++ {
+		answered := branch
+		answered.Messages = append(slices.Clip(branch.Messages), msg)
+		if len(payload.Replay) > 0 {
+			answered.Messages = append(answered.Messages, payload.Replay[0])
+		}
+		err = inTx(ctx, q, func(ctx context.Context, q *db.Queries) error {
+			if err := updateBranchMessages(ctx, q, chat.ID, &answered); err != nil {
+				return err
+			}
+			if len(payload.Replay) == 0 {
+				return nil
+			}
+			// Job is replaced by the next step together with the save, so
+			// the same prompt isn't replayed twice after a restart
+			err := h.enqueueJob(ctx, q, jobMessage, chat.ID, branch.ID.String(), messageJob{
+				MentionIDs: payload.MentionIDs,
+				Replay:     payload.Replay[1:],
+			})
+			if err != nil {
+				return err
+			}
+			return q.DeleteJob(ctx, job.ID)
+		})
+		if !errors.Is(err, errConflict) || attempt == maxUpdateAttempts {
+			break
+		}
+		branch, err = findChatBranch(ctx, q, chat.ID, branch.ID)
+		if err != nil {
+			break
+		}
+		// Checkpoint is replaced by the answer
+		if n := len(branch.Messages); n > 0 && branch.Messages[n-1].Incomplete {
+			branch.Messages = branch.Messages[:n-1]
+		}
+	}
+	if err != nil {
+		slog.Error("failed to save chat after generation", "with", err)
+	}
+	return err
+}
+
+func (h ChatHandler) runTitleJob(ctx context.Context, q *db.Queries, job db.ClaimJobRow) error {
+	var payload titleJob
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return fmt.Errorf("failed to decode job with %w", err)
+	}
+	chatID, err := uuid.Parse(job.ChatID)
+	if err != nil {
+		return err
+	}
+
+	stream := h.titleChan.Alloc(ctx, chatID)
+	defer h.titleChan.Free(stream)
+
+	// Generate title
+	t, err := genTitle(ctx, h.g, payload.Prompt)
+	if err != nil {
+		return fmt.Errorf("failed to generate title with %w", err)
+	}
+
+	// Publish title
+	stream.Chunks <- t
+
+	// Persist title
+	err = q.SaveChatTitle(ctx, db.SaveChatTitleParams{
+		ID:    chatID.String(),
+		Title: t,
+	})
+	if err != nil {
+		slog.Error("failed to save chat title", "with", err)
+		return err
+	}
+	publishFeed(ctx, feedEvent{Type: feedTitleGenerated, ChatID: chatID.String(), Title: t})
+	return nil
+}
+
+func (h ChatHandler) runBranchNameJob(ctx context.Context, q *db.Queries, job db.ClaimJobRow) error {
+	var payload branchNameJob
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return fmt.Errorf("failed to decode job with %w", err)
+	}
+	chatID, err := uuid.Parse(job.ChatID)
+	if err != nil {
+		return err
+	}
+	branchID, err := uuid.Parse(job.BranchID)
+	if err != nil {
+		return err
+	}
+
+	// Retried job shouldn't override the name given by the user meanwhile
+	branch, err := q.FindChatBranch(ctx, db.FindChatBranchParams{
+		ChatID: chatID.String(),
+		ID:     branchID.String(),
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to find branch with %w", err)
+	}
+	if branch.Name != "" || branch.DeletedAt.Valid {
+		return nil
+	}
+	return nameBranch(ctx, h.g, q, chatID, branchID, payload.Prompt)
+}
+
+// Queues the failed generation of the branch message again
+func (h ChatHandler) postJobRetry(w http.ResponseWriter, r *http.Request) {
+	// Validate data
+	chatID, err := deserID(w, r)
+	var errs []error
+	if err != nil {
+		errs = append(errs, err)
+	}
+	// Branch param always exists because of routing
+	branchID, _, err := deserBranchID(w, r)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		http.Error(w, errors.Join(errs...).Error(), http.StatusBadRequest)
+		return
+	}
+
+	q, err := h.getQueries(w, r)
+	if err != nil {
+		return
+	}
+
+	// Post of the same branch could queue a job in the meantime
+	unlock := h.branchLocks.Lock(branchID)
+	defer unlock()
+	row, err := q.FindLatestJob(r.Context(), db.FindLatestJobParams{
+		ChatID:   chatID.String(),
+		BranchID: branchID.String(),
+		Kind:     jobMessage,
+	})
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && row.Status != jobFailed) {
+		http.Error(w, "There is no failed generation", http.StatusNotFound)
+		return
+	}
+	if err == nil {
+		err = q.RequeueFailedJob(r.Context(), row.ID)
+	}
+	if err != nil {
+		slog.Error("failed to requeue job", "with", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.wakeJobs()
+
+	w.Header().Set("HX-Refresh", "true")
+}
+
+// Queues generation of the rest of the checkpointed branch answer
+func (h ChatHandler) postMessageContinue(w http.ResponseWriter, r *http.Request) {
+	// Validate data
+	chatID, err := deserID(w, r)
+	var errs []error
+	if err != nil {
+		errs = append(errs, err)
+	}
+	// Branch param always exists because of routing
+	branchID, _, err := deserBranchID(w, r)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		http.Error(w, errors.Join(errs...).Error(), http.StatusBadRequest)
+		return
+	}
+
+	q, err := h.getQueries(w, r)
+	if err != nil {
+		return
+	}
+
+	// Posts of the same branch are checked under the lock as well
+	unlock := h.branchLocks.Lock(branchID)
+	defer unlock()
+	if h.generating(r.Context(), q, chatID, branchID) {
+		http.Error(w, "Branch is generating a message", http.StatusConflict)
+		return
+	}
+	branch, err := findChatBranch(r.Context(), q, chatID, branchID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	n := len(branch.Messages)
+	if n == 0 || !branch.Messages[n-1].Incomplete {
+		http.Error(w, "There is no incomplete answer", http.StatusNotFound)
+		return
+	}
+
+	// Interrupted job is queued again, so mentions & replay are the same
+	var payload any = messageJob{}
+	if p := branch.Messages[n-1].JobPayload; p != "" {
+		payload = json.RawMessage(p)
+	}
+	err = h.enqueueJob(r.Context(), q, jobMessage, chatID, branchID.String(), payload)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("HX-Refresh", "true")
+}
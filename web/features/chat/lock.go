@@ -0,0 +1,47 @@
+package chat
+
+import (
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// Mutexes of separate branches, so changes of one branch are serialized
+// without blocking the others. Locks are held by this process only
+type branchLocks struct {
+	l sync.Mutex
+	m map[uuid.UUID]*branchLock
+}
+
+type branchLock struct {
+	l sync.Mutex
+	// Holder & waiters, the lock is dropped when nobody uses it
+	refs int
+}
+
+func newBranchLocks() *branchLocks {
+	return &branchLocks{m: make(map[uuid.UUID]*branchLock)}
+}
+
+// Blocks until the branch is free, returned func releases it
+func (b *branchLocks) Lock(id uuid.UUID) (unlock func()) {
+	b.l.Lock()
+	bl, ok := b.m[id]
+	if !ok {
+		bl = &branchLock{}
+		b.m[id] = bl
+	}
+	bl.refs++
+	b.l.Unlock()
+
+	bl.l.Lock()
+	return func() {
+		bl.l.Unlock()
+		b.l.Lock()
+		defer b.l.Unlock()
+		bl.refs--
+		if bl.refs == 0 {
+			delete(b.m, id)
+		}
+	}
+}
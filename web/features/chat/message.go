@@ -0,0 +1,302 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+
+	"shellshift/internal/db"
+	"shellshift/internal/ulid"
+)
+
+// Content of the message, copies of the message in other branches have
+// their own IDs but the same key
+type messageKey struct {
+	Role         string
+	Text         string
+	SquashedFrom string
+}
+
+func (m Message) key() messageKey {
+	return messageKey{Role: m.Role, Text: m.Text, SquashedFrom: m.SquashedFrom}
+}
+
+func messageKeys(msgs []Message) []messageKey {
+	keys := make([]messageKey, len(msgs))
+	for i, msg := range msgs {
+		keys[i] = msg.key()
+	}
+	return keys
+}
+
+// Finds messages of main or the branch in their order
+func findMessages(ctx context.Context, q *db.Queries, chatID, branchID uuid.UUID) ([]Message, error) {
+	rows, err := q.FindMessages(ctx, db.FindMessagesParams{
+		ChatID:   chatID.String(),
+		BranchID: branchID.String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find messages with %w", err)
+	}
+	msgs := make([]Message, len(rows))
+	for i, row := range rows {
+		msgs[i] = messageFromRow(row)
+	}
+	return msgs, nil
+}
+
+func messageFromRow(row db.FindMessagesRow) Message {
+	msg := Message{
+		ID:           row.ID,
+		Text:         row.Content,
+		Role:         row.Role,
+		Model:        row.Model,
+		InputTokens:  int(row.InputTokens),
+		OutputTokens: int(row.OutputTokens),
+		SquashedFrom: row.SquashedFrom,
+		Incomplete:   row.Incomplete,
+		JobPayload:   string(row.JobPayload),
+	}
+	if row.CreatedAt > 0 {
+		msg.CreatedAt = time.Unix(row.CreatedAt, 0).UTC()
+	}
+	return msg
+}
+
+// Writes messages of main or the branch. Rows of the unchanged beginning
+// are kept, so appending a message inserts a single row
+func saveMessages(ctx context.Context, q *db.Queries, chatID, branchID uuid.UUID, msgs []Message) error {
+	prev, err := findMessages(ctx, q, chatID, branchID)
+	if err != nil {
+		return err
+	}
+
+	// Messages without ID weren't saved yet or were read before the save
+	next := slices.Clone(msgs)
+	prefix := 0
+	for prefix < min(len(prev), len(next)) &&
+		(next[prefix].ID == "" || next[prefix].ID == prev[prefix].ID) &&
+		next[prefix].key() == prev[prefix].key() {
+		next[prefix] = prev[prefix]
+		prefix++
+	}
+
+	// Rewritten messages keep their IDs, copies from elsewhere get new ones
+	rewritten := make(map[string]bool, len(prev)-prefix)
+	for _, msg := range prev[prefix:] {
+		rewritten[msg.ID] = true
+	}
+	if prefix < len(prev) {
+		err = q.DeleteMessagesFrom(ctx, db.DeleteMessagesFromParams{
+			ChatID:   chatID.String(),
+			BranchID: branchID.String(),
+			Position: int64(prefix),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to delete messages with %w", err)
+		}
+	}
+	for i := prefix; i < len(next); i++ {
+		msg := &next[i]
+		if !rewritten[msg.ID] {
+			msg.ID = ulid.New()
+		}
+		var createdAt int64
+		if !msg.CreatedAt.IsZero() {
+			msg.CreatedAt = msg.CreatedAt.Truncate(time.Second)
+			createdAt = msg.CreatedAt.Unix()
+		}
+		err = q.SaveMessage(ctx, db.SaveMessageParams{
+			ID:           msg.ID,
+			ChatID:       chatID.String(),
+			BranchID:     branchID.String(),
+			Position:     int64(i),
+			Role:         msg.Role,
+			Content:      msg.Text,
+			Model:        msg.Model,
+			InputTokens:  int64(msg.InputTokens),
+			OutputTokens: int64(msg.OutputTokens),
+			SquashedFrom: msg.SquashedFrom,
+			Incomplete:   msg.Incomplete,
+			JobPayload:   []byte(msg.JobPayload),
+			CreatedAt:    createdAt,
+		})
+		if err != nil {
+			slog.Error("failed to save message", "position", i, "with", err)
+			return fmt.Errorf("failed to save message with %w", err)
+		}
+	}
+	return logMessagesChange(ctx, q, chatID, branchID, prev, next)
+}
+
+// Saves partial answer at the end of the branch while it's generated, so it
+// survives page reloads & restarts. Only the first checkpoint is logged,
+// later ones update the saved text in place
+type checkpointer struct {
+	q      *db.Queries
+	chatID uuid.UUID
+	// Messages before the answer, its version follows the checkpoints
+	branch *Branch
+	// Set once the incomplete answer is saved
+	saved bool
+	// Payload of the generating job, saved with the answer
+	payload []byte
+}
+
+func (c *checkpointer) save(ctx context.Context, text string) {
+	if !c.saved {
+		b := *c.branch
+		b.Messages = append(slices.Clip(b.Messages), Message{
+			Text:       text,
+			Role:       "model",
+			Model:      defaultModel,
+			Incomplete: true,
+			JobPayload: string(c.payload),
+			CreatedAt:  time.Now().UTC(),
+		})
+		if err := updateBranchMessages(ctx, c.q, c.chatID, &b); err != nil {
+			slog.Error("failed to save checkpoint", "with", err)
+			return
+		}
+		c.branch.Version = b.Version
+		c.saved = true
+		return
+	}
+	err := c.q.UpdateMessageContent(ctx, db.UpdateMessageContentParams{
+		Content:  text,
+		ChatID:   c.chatID.String(),
+		BranchID: c.branch.ID.String(),
+		Position: int64(len(c.branch.Messages)),
+	})
+	if err != nil {
+		slog.Error("failed to update checkpoint", "with", err)
+	}
+}
+
+// Amount of messages rendered at once, older ones are loaded on scroll
+const messagePageSize = 50
+
+// Page of main or branch messages ending before the cursor
+type messagePage struct {
+	ChatID   string
+	BranchID string
+	Main     bool
+	Messages []HTMLMessage
+	// Comments of the page messages in the same order
+	Comments []MessageComments
+	// Index of the first page message, zero when there is nothing older
+	Before  int
+	BaseURI string
+}
+
+// Renders messages from start, which should be placed before their index
+func newMessagePage(chatID, branchID uuid.UUID, start int, msgs []Message, comments []Comment, baseURI string) messagePage {
+	page := messagePage{
+		ChatID:   chatID.String(),
+		BranchID: branchID.String(),
+		Main:     branchID == mainBranchID,
+		Messages: make([]HTMLMessage, len(msgs)),
+		Before:   start,
+		BaseURI:  baseURI,
+	}
+	for i, msg := range msgs {
+		page.Messages[i] = renderMessage(msg)
+		page.Messages[i].Idx = start + i
+	}
+
+	// Comments of newer messages belong to the next pages
+	end := start + len(msgs)
+	comments = slices.DeleteFunc(slices.Clone(comments), func(c Comment) bool { return c.MessageIdx >= end })
+	page.Comments = groupComments(comments, chatID, branchID, end, baseURI)[start:]
+	return page
+}
+
+// Finds up to limit messages of main or the branch placed before the
+// position, start is the index of the first found one. Positions go without
+// gaps, so the last page is found before the max position & the amount of
+// messages is the end of it
+func findMessagesBefore(ctx context.Context, q *db.Queries, chatID, branchID uuid.UUID, before int64, limit int) (msgs []Message, start int, _ error) {
+	rows, err := q.FindMessagesBefore(ctx, db.FindMessagesBeforeParams{
+		ChatID:   chatID.String(),
+		BranchID: branchID.String(),
+		Position: before,
+		Limit:    int64(limit),
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to find messages with %w", err)
+	}
+	if len(rows) == 0 {
+		return nil, 0, nil
+	}
+	msgs = make([]Message, len(rows))
+	for i, row := range rows {
+		// Rows are selected from the newest one
+		msgs[len(rows)-1-i] = messageFromRow(db.FindMessagesRow{
+			ID:           row.ID,
+			Role:         row.Role,
+			Content:      row.Content,
+			Model:        row.Model,
+			InputTokens:  row.InputTokens,
+			OutputTokens: row.OutputTokens,
+			SquashedFrom: row.SquashedFrom,
+			Incomplete:   row.Incomplete,
+			CreatedAt:    row.CreatedAt,
+		})
+	}
+	return msgs, int(rows[len(rows)-1].Position), nil
+}
+
+// Loads page of messages older than the one at the before index
+func (h ChatHandler) getMessages(w http.ResponseWriter, r *http.Request) {
+	// Validate data
+	chatID, err := deserID(w, r)
+	var errs []error
+	if err != nil {
+		errs = append(errs, err)
+	}
+	// Branch param always exists because of routing, main is the nil ID
+	branchID, _, err := deserBranchID(w, r)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	before, err := strconv.Atoi(r.URL.Query().Get("before"))
+	if err != nil || before <= 0 {
+		errs = append(errs, fmt.Errorf("before should be a positive message index"))
+	}
+	if len(errs) > 0 {
+		http.Error(w, errors.Join(errs...).Error(), http.StatusBadRequest)
+		return
+	}
+
+	q, err := h.getQueries(w, r)
+	if err != nil {
+		return
+	}
+
+	msgs, _, err := findMessagesBefore(r.Context(), q, chatID, branchID, int64(before), messagePageSize)
+	if err != nil {
+		slog.Error("failed to find messages page", "with", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	comments, err := findComments(r.Context(), q, chatID, branchID)
+	if err != nil {
+		slog.Error("failed to find comments", "err", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	page := newMessagePage(chatID, branchID, before-len(msgs), msgs, comments, h.baseURI)
+	err = h.templates.Render(w, "messages-page", page)
+	if err != nil {
+		slog.Error("failed to render messages page", "with", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
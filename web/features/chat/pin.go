@@ -0,0 +1,71 @@
+package chat
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"shellshift/internal/db"
+)
+
+type ChatFlags struct {
+	ID       string
+	Pinned   bool
+	Archived bool
+	BaseURI  string
+}
+
+func (h ChatHandler) postPin(w http.ResponseWriter, r *http.Request) {
+	h.updateChatFlag(w, r, (*db.Queries).PinChat)
+}
+
+func (h ChatHandler) deletePin(w http.ResponseWriter, r *http.Request) {
+	h.updateChatFlag(w, r, (*db.Queries).UnpinChat)
+}
+
+func (h ChatHandler) postArchive(w http.ResponseWriter, r *http.Request) {
+	h.updateChatFlag(w, r, (*db.Queries).ArchiveChat)
+}
+
+func (h ChatHandler) deleteArchive(w http.ResponseWriter, r *http.Request) {
+	h.updateChatFlag(w, r, (*db.Queries).UnarchiveChat)
+}
+
+// Applies pin / archive query to the chat and renders its new flags
+func (h ChatHandler) updateChatFlag(w http.ResponseWriter, r *http.Request, update func(*db.Queries, context.Context, string) error) {
+	// Validate id
+	id, err := deserID(w, r)
+	if err != nil {
+		return
+	}
+
+	q, err := h.getQueries(w, r)
+	if err != nil {
+		return
+	}
+
+	// Update flag
+	if err := update(q, r.Context(), id.String()); err != nil {
+		slog.Error("failed to update chat flag", "id", id, "with", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Render actual state
+	chat, err := findChat(r.Context(), q, id)
+	if err != nil {
+		slog.Error("failed to find chat", "err", err.Error())
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	err = h.templates.Render(w, "chat-flags", ChatFlags{
+		ID:       chat.ID.String(),
+		Pinned:   chat.Pinned,
+		Archived: chat.Archived,
+		BaseURI:  h.baseURI,
+	})
+	if err != nil {
+		slog.Error("failed to render chat flags", "with", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
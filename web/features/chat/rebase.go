@@ -0,0 +1,144 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"shellshift/internal/db"
+)
+
+func (h ChatHandler) postRebase(w http.ResponseWriter, r *http.Request) {
+	// Validate data
+	chatID, err := deserID(w, r)
+	var errs []error
+	if err != nil {
+		errs = append(errs, err)
+	}
+	// Branch param always exists because of routing
+	branchID, _, err := deserBranchID(w, r)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		http.Error(w, errors.Join(errs...).Error(), http.StatusBadRequest)
+		return
+	}
+	replay := r.FormValue("replay") == "true"
+
+	q, err := h.getQueries(w, r)
+	if err != nil {
+		return
+	}
+
+	// Generated message would be saved on top of the old origin
+	unlock := h.branchLocks.Lock(branchID)
+	defer unlock()
+	if h.generating(r.Context(), q, chatID, branchID) {
+		http.Error(w, "Branch is generating a message", http.StatusConflict)
+		return
+	}
+
+	chat, err := findChat(r.Context(), q, chatID)
+	if err != nil {
+		slog.Error("failed to find chat", "err", err.Error())
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	branch, err := findChatBranch(r.Context(), q, chatID, branchID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(branch.Messages) == 0 {
+		http.Error(w, "Branch doesn't exist", http.StatusNotFound)
+		return
+	}
+	tip := len(chat.Messages) - 1
+
+	// Move origin of the branch itself
+	if !replay {
+		if branch.Origin == tip {
+			http.Error(w, "Branch is already based on the latest main message", http.StatusBadRequest)
+			return
+		}
+		err = rebaseBranch(r.Context(), q, chatID, LogBranchRebased{
+			BranchID:         branchID.String(),
+			OriginMessageIdx: tip,
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("HX-Refresh", "true")
+		return
+	}
+
+	// Replay user prompts into the new branch, so the original is kept
+	var prompts []Message
+	for _, msg := range branch.Messages {
+		if msg.Role == "user" {
+			prompts = append(prompts, msg)
+		}
+	}
+	if len(prompts) == 0 {
+		http.Error(w, "Branch has no prompts to replay", http.StatusBadRequest)
+		return
+	}
+	rebased := Branch{
+		ID:       uuid.New(),
+		Messages: []Message{prompts[0]},
+		Origin:   tip,
+	}
+	// Keep the name, so branches are easy to match
+	name := branch.DisplayName()
+	for len(name) > maxBranchNameLength-len(" rebased") {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	name += " rebased"
+
+	err = inTx(r.Context(), q, func(ctx context.Context, q *db.Queries) error {
+		err := createBranch(ctx, q, chatID, &rebased)
+		if err == nil {
+			err = saveChatLog(ctx, q, chatID, LogBranchRebased{
+				BranchID:         rebased.ID.String(),
+				OriginMessageIdx: tip,
+				SourceBranchID:   branchID.String(),
+			})
+		}
+		if err != nil {
+			return err
+		}
+		err = q.UpdateChatBranchName(ctx, db.UpdateChatBranchNameParams{
+			Name:   name,
+			ChatID: chatID.String(),
+			ID:     rebased.ID.String(),
+		})
+		if err == nil {
+			err = saveChatLog(ctx, q, chatID, LogBranchRenamed{
+				BranchID: rebased.ID.String(),
+				Name:     name,
+			})
+		}
+		if err != nil {
+			slog.Error("failed to name rebased branch", "with", err)
+			return err
+		}
+
+		// Prompts are answered one by one, every answer is a job carrying
+		// the rest of the prompts, so the replay survives restarts
+		return h.enqueueJob(ctx, q, jobMessage, chatID, rebased.ID.String(), messageJob{Replay: prompts[1:]})
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("HX-Redirect", fmt.Sprintf("%s/%s/branch/%s", h.baseURI, chatID, rebased.ID))
+}
@@ -0,0 +1,176 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"shellshift/internal/db"
+)
+
+// Messages appended to main by the merge
+type mergeBlock struct {
+	ID       string
+	BranchID string
+	// Index of the first merged message in the current main, valid until reverted
+	Start       int
+	Amount      int
+	MessageIdxs []int
+	Squashed    bool
+	Reverted    bool
+	// Merged messages were moved out of their positions by split or combine
+	Detached bool
+}
+
+// Replays merges & their reverts in the log order. Reverted merges shift
+// positions of the merges which were placed after them
+func findMerges(log []LogEntry) []mergeBlock {
+	var merges []mergeBlock
+	for _, entry := range log {
+		switch m := entry.Meta.(type) {
+		case LogBranchMerged:
+			id := m.MergeID
+			if id == "" {
+				id = fmt.Sprintf("legacy-%d", len(merges))
+			}
+			merges = append(merges, mergeBlock{
+				ID:          id,
+				BranchID:    m.BranchID,
+				Start:       m.MergedAtMessageIdX + 1,
+				Amount:      m.MergedAmount,
+				MessageIdxs: m.MessageIdxs,
+				Squashed:    m.Squashed,
+			})
+		case LogBranchMergeReverted:
+			for i := range merges {
+				if merges[i].Reverted || merges[i].Detached {
+					continue
+				}
+				if merges[i].ID == m.MergeID {
+					merges[i].Reverted = true
+				} else if merges[i].Start > m.MessageIdx {
+					merges[i].Start -= m.Amount
+				}
+			}
+		case LogChatSplit:
+			// Messages after the split index were moved to the new chat
+			for i := range merges {
+				switch {
+				case merges[i].Reverted || merges[i].Detached:
+				case merges[i].Start >= m.MessageIdx:
+					merges[i].Detached = true
+				case merges[i].Start+merges[i].Amount > m.MessageIdx:
+					merges[i].Amount = m.MessageIdx - merges[i].Start
+				}
+			}
+		case LogChatsCombined:
+			// Appended messages don't move merges, interleaved ones do
+			if m.Mode != combineInterleave {
+				continue
+			}
+			for i := range merges {
+				merges[i].Detached = true
+			}
+		}
+	}
+	return merges
+}
+
+func (h ChatHandler) postMergeRevert(w http.ResponseWriter, r *http.Request) {
+	// Validate data
+	chatID, err := deserID(w, r)
+	if err != nil {
+		return
+	}
+	mergeID := r.PathValue("mergeId")
+
+	q, err := h.getQueries(w, r)
+	if err != nil {
+		return
+	}
+
+	log, err := findChatLog(r.Context(), q, chatID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	merges := findMerges(log)
+	i := slices.IndexFunc(merges, func(m mergeBlock) bool { return m.ID == mergeID })
+	if i == -1 {
+		http.Error(w, "Merge doesn't exist", http.StatusNotFound)
+		return
+	}
+	merge := merges[i]
+	if merge.Reverted {
+		http.Error(w, "Merge is already reverted", http.StatusConflict)
+		return
+	}
+	if merge.Detached {
+		http.Error(w, "Merged messages were moved by split or combine", http.StatusConflict)
+		return
+	}
+
+	chat, err := findChat(r.Context(), q, chatID)
+	if err != nil {
+		slog.Error("failed to find chat", "err", err.Error())
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if merge.Start < 0 || merge.Start+merge.Amount > len(chat.Messages) {
+		slog.Error("merged messages are out of main", "mergeId", mergeID, "start", merge.Start, "amount", merge.Amount)
+		http.Error(w, "Merged messages are not found in main", http.StatusConflict)
+		return
+	}
+
+	// Remove exactly merged messages, branches & comments based on them are
+	// moved back
+	chat.Messages = slices.Delete(chat.Messages, merge.Start, merge.Start+merge.Amount)
+	err = inTx(r.Context(), q, func(ctx context.Context, q *db.Queries) error {
+		if err := updateChatMessages(ctx, q, &chat); err != nil {
+			return err
+		}
+		err := q.ShiftChatBranchOrigins(ctx, db.ShiftChatBranchOriginsParams{
+			MessageIdx: int64(merge.Start),
+			Amount:     int64(merge.Amount),
+			ChatID:     chatID.String(),
+		})
+		if err != nil {
+			slog.Error("failed to shift branch origins", "with", err)
+			return err
+		}
+		// Comments are kept by message index, so the ones on removed messages
+		// go away & the later ones follow their messages
+		err = q.DeleteRangeComments(ctx, db.DeleteRangeCommentsParams{
+			ChatID:   chatID.String(),
+			BranchID: mainBranchID.String(),
+			Start:    int64(merge.Start),
+			End:      int64(merge.Start + merge.Amount),
+		})
+		if err == nil {
+			err = q.ShiftComments(ctx, db.ShiftCommentsParams{
+				Amount:     int64(merge.Amount),
+				ChatID:     chatID.String(),
+				BranchID:   mainBranchID.String(),
+				MessageIdx: int64(merge.Start + merge.Amount),
+			})
+		}
+		if err != nil {
+			slog.Error("failed to move comments of reverted messages", "with", err)
+			return err
+		}
+		return saveChatLog(ctx, q, chatID, LogBranchMergeReverted{
+			MergeID:    merge.ID,
+			BranchID:   merge.BranchID,
+			MessageIdx: merge.Start,
+			Amount:     merge.Amount,
+		})
+	})
+	if err != nil {
+		writeUpdateError(w, err)
+		return
+	}
+
+	w.Header().Set("HX-Refresh", "true")
+}
@@ -8,6 +8,10 @@ import (
 	"fmt"
 	"html/template"
 	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/firebase/genkit/go/ai"
 	"github.com/firebase/genkit/go/genkit"
@@ -23,38 +27,103 @@ type Chat struct {
 	ID       uuid.UUID
 	Title    string
 	Messages []Message
+	Pinned   bool `json:"-"`
+	Archived bool `json:"-"`
+	// Incremented by every update of the messages
+	Version int64 `json:"-"`
 }
 
 type Message struct {
+	// Empty until the message is saved
+	ID   string `json:",omitempty"`
 	Text string
 	Role string
+	// Model which generated the message
+	Model        string `json:",omitempty"`
+	InputTokens  int    `json:",omitempty"`
+	OutputTokens int    `json:",omitempty"`
+	// Branch which was squashed into the message
+	SquashedFrom string `json:",omitempty"`
+	// Answer which was checkpointed while being generated and isn't finished
+	Incomplete bool `json:",omitempty"`
+	// Payload of the job generating the incomplete answer, so it's continued
+	// with the same mentions & replay
+	JobPayload string `json:"-"`
+	// Zero for messages created before timestamps were stored
+	CreatedAt time.Time `json:",omitzero"`
 }
 
+// Returned for chats & branches which were moved to the trash
+var errTrashed = errors.New("moved to the trash")
+
+// Returned when messages were updated by another request since they were
+// loaded, the update should be retried with the fresh state
+var errConflict = errors.New("changed by another request")
+
+// Attempts of the background updates which are retried on conflicts
+const maxUpdateAttempts = 3
+
 func findChat(ctx context.Context, q *db.Queries, id uuid.UUID) (Chat, error) {
-	chat, err := q.FindChat(ctx, id.String())
+	chat, err := findChatInfo(ctx, q, id)
 	if err != nil {
 		return Chat{}, err
 	}
-	var msgs []Message
-	err = json.Unmarshal(chat.Messages, &msgs)
+	chat.Messages, err = findMessages(ctx, q, id, mainBranchID)
 	if err != nil {
 		return Chat{}, err
 	}
+	return chat, nil
+}
+
+// Finds the chat without its messages
+func findChatInfo(ctx context.Context, q *db.Queries, id uuid.UUID) (Chat, error) {
+	chat, err := q.FindChat(ctx, id.String())
+	if err != nil {
+		return Chat{}, err
+	}
+	if chat.DeletedAt.Valid {
+		return Chat{}, errTrashed
+	}
 	return Chat{
 		ID:       id,
 		Title:    chat.Title,
-		Messages: msgs,
+		Pinned:   chat.PinnedAt.Valid,
+		Archived: chat.ArchivedAt.Valid,
+		Version:  chat.Version,
 	}, nil
 }
 
 type Branch struct {
 	ID       uuid.UUID
 	Messages []Message
+	// Derived from the chat log, so it's only filled for the branch list &
+	// by loadBranchState
+	State       BranchState
+	UpdatedAt   time.Time
+	Name        string
+	Description string
+	Abandoned   bool
+	// Index of the main message branch is based on, -1 for empty main
+	Origin int
+	// Incremented by every update of the messages, zero for new branches
+	Version int64
+}
+
+func findChatBranch(ctx context.Context, q *db.Queries, chatID uuid.UUID, branchID uuid.UUID) (Branch, error) {
+	b, ok, err := findChatBranchInfo(ctx, q, chatID, branchID)
+	if !ok || err != nil {
+		return b, err
+	}
+	b.Messages, err = findMessages(ctx, q, chatID, branchID)
+	return b, err
 }
 
-func findChatBranch(ctx context.Context, q *db.Queries, chatID uuid.UUID, branchID uuid.UUID) (b Branch, _ error) {
+// Finds the branch without its messages, the one which doesn't exist is
+// returned with its ID only
+func findChatBranchInfo(ctx context.Context, q *db.Queries, chatID uuid.UUID, branchID uuid.UUID) (b Branch, ok bool, _ error) {
 	b.ID = branchID
-	msgsBlob, err := q.FindChatBranch(ctx, db.FindChatBranchParams{
+	b.Origin = -1
+	row, err := q.FindChatBranch(ctx, db.FindChatBranchParams{
 		ID:     branchID.String(),
 		ChatID: chatID.String(),
 	})
@@ -62,15 +131,20 @@ func findChatBranch(ctx context.Context, q *db.Queries, chatID uuid.UUID, branch
 	case nil:
 		break
 	case sql.ErrNoRows:
-		return b, nil
+		return b, false, nil
 	default:
-		return b, err
+		return b, false, err
 	}
-	err = json.Unmarshal(msgsBlob, &b.Messages)
-	if err != nil {
-		return b, err
+	if row.DeletedAt.Valid {
+		return b, false, errTrashed
 	}
-	return b, nil
+	b.UpdatedAt = time.Unix(row.UpdatedAt, 0)
+	b.Name = row.Name
+	b.Description = row.Description
+	b.Abandoned = row.AbandonedAt.Valid
+	b.Version = row.Version
+	b.Origin = int(row.Origin)
+	return b, true, nil
 }
 
 func findChatsTitles(q *db.Queries) ([]db.FindChatTitlesRow, error) {
@@ -107,168 +181,156 @@ func genTitle(ctx context.Context, g *genkit.Genkit, msg string) (string, error)
 
 func saveChat(ctx context.Context, q *db.Queries, c Chat) error {
 	slog.Info("saving chat", "id", c.ID)
-	encoded, err := json.Marshal(c.Messages)
-	if err != nil {
-		slog.Error("failed to encode messages", "err", err)
-		return err
-	}
-	err = q.SaveChat(ctx, db.SaveChatParams{
-		ID:       c.ID.String(),
-		Title:    c.Title,
-		Messages: encoded,
+	return inTx(ctx, q, func(ctx context.Context, q *db.Queries) error {
+		err := q.SaveChat(ctx, db.SaveChatParams{
+			ID:    c.ID.String(),
+			Title: c.Title,
+		})
+		if err != nil {
+			slog.Error("failed to save chat", "err", err)
+			return err
+		}
+		err = saveMessages(ctx, q, c.ID, mainBranchID, c.Messages)
+		if err != nil {
+			return err
+		}
+		afterCommit(ctx, func() {
+			publishFeed(ctx, feedEvent{Type: feedChatCreated, ChatID: c.ID.String(), Title: c.Title})
+		})
+		return nil
 	})
-	if err != nil {
-		slog.Error("failed to save chat", "err", err)
-		return err
-	}
-	return nil
 }
 
-func updateChatMessages(ctx context.Context, q *db.Queries, c Chat) error {
+// Version of the chat is checked & incremented in the same transaction with
+// the messages, so only one of concurrent updates succeeds and the rest get
+// errConflict
+func updateChatMessages(ctx context.Context, q *db.Queries, c *Chat) error {
 	slog.Info("updating chat messages", "id", c.ID)
-	encoded, err := json.Marshal(c.Messages)
-	if err != nil {
-		slog.Error("failed to encode messages", "err", err)
+	err := inTx(ctx, q, func(ctx context.Context, q *db.Queries) error {
+		n, err := q.UpdateChatVersion(ctx, db.UpdateChatVersionParams{
+			ID:      c.ID.String(),
+			Version: c.Version,
+		})
+		if err != nil {
+			slog.Error("failed to update chat version", "err", err)
+			return err
+		}
+		if n == 0 {
+			slog.Warn("chat was changed concurrently", "id", c.ID, "version", c.Version)
+			return errConflict
+		}
+		err = saveMessages(ctx, q, c.ID, mainBranchID, c.Messages)
+		if err != nil {
+			slog.Error("failed to update chat messages", "err", err)
+		}
 		return err
-	}
-	err = q.UpdateChatMessages(ctx, db.UpdateChatMessagesParams{
-		ID:       c.ID.String(),
-		Messages: encoded,
 	})
-	if err != nil {
-		slog.Error("failed to update chat messages", "err", err)
-		return err
+	if err == nil {
+		c.Version++
 	}
-	return nil
-}
-
-type ChatLogger interface {
-	encodeLogEntry() []byte
-	fromEncoded(enc []byte) (ChatLogger, error)
-	getActionName() string
-}
-
-type LogBranchCreated struct {
-	BranchID         string
-	OriginMessageIdx int
-}
-
-func (l LogBranchCreated) encodeLogEntry() []byte {
-	encoded, _ := json.Marshal(l)
-	return encoded
-}
-
-func (l LogBranchCreated) fromEncoded(enc []byte) (ChatLogger, error) {
-	var logger LogBranchCreated
-	err := json.Unmarshal(enc, &logger)
-	return logger, err
-}
-
-func (l LogBranchCreated) getActionName() string {
-	return "branch-created"
-}
-
-type LogBranchMerged struct {
-	BranchID           string
-	MergedAtMessageIdX int
-	MergedAmount       int
-}
-
-func (l LogBranchMerged) encodeLogEntry() []byte {
-	encoded, _ := json.Marshal(l)
-	return encoded
-}
-
-func (l LogBranchMerged) fromEncoded(enc []byte) (ChatLogger, error) {
-	var logger LogBranchMerged
-	err := json.Unmarshal(enc, &logger)
-	return logger, err
-}
-
-func (l LogBranchMerged) getActionName() string {
-	return "branch-merged"
+	return err
 }
 
-func saveChatLog[T ChatLogger](ctx context.Context, q *db.Queries, chatID uuid.UUID, entry T) error {
-	err := q.SaveChatLog(ctx, db.SaveChatLogParams{
-		ChatID: chatID.String(),
-		Action: entry.getActionName(),
-		Meta:   entry.encodeLogEntry(),
+// Branch is created when it doesn't exist, its version is checked the same
+// way as for chat
+func updateBranchMessages(ctx context.Context, q *db.Queries, chatID uuid.UUID, b *Branch) error {
+	slog.Info("updating branch messages", "chatId", chatID, "id", b.ID)
+	err := inTx(ctx, q, func(ctx context.Context, q *db.Queries) error {
+		err := q.SaveOrTouchChatBranch(ctx, db.SaveOrTouchChatBranchParams{
+			ID:     b.ID.String(),
+			ChatID: chatID.String(),
+		})
+		if err != nil {
+			slog.Error("failed to persist branch", "err", err)
+			return err
+		}
+		n, err := q.UpdateChatBranchVersion(ctx, db.UpdateChatBranchVersionParams{
+			ChatID:  chatID.String(),
+			ID:      b.ID.String(),
+			Version: b.Version,
+		})
+		if err != nil {
+			slog.Error("failed to update branch version", "err", err)
+			return err
+		}
+		if n == 0 {
+			slog.Warn("branch was changed concurrently", "id", b.ID, "version", b.Version)
+			return errConflict
+		}
+		err = saveMessages(ctx, q, chatID, b.ID, b.Messages)
+		if err != nil {
+			slog.Error("failed to persist branch messages", "err", err)
+		}
+		return err
 	})
-	if err != nil {
-		slog.Error("failed to save chat log", "action", entry.getActionName(), "with", err)
+	if err == nil {
+		b.Version++
 	}
 	return err
 }
 
-type LogEntry struct {
-	Action string
-	Meta   ChatLogger
+// Saves messages of the new branch & logs its creation
+func createBranch(ctx context.Context, q *db.Queries, chatID uuid.UUID, b *Branch) error {
+	return inTx(ctx, q, func(ctx context.Context, q *db.Queries) error {
+		if err := updateBranchMessages(ctx, q, chatID, b); err != nil {
+			return err
+		}
+		err := q.UpdateChatBranchOrigin(ctx, db.UpdateChatBranchOriginParams{
+			Origin: int64(b.Origin),
+			ChatID: chatID.String(),
+			ID:     b.ID.String(),
+		})
+		if err != nil {
+			slog.Error("failed to save branch origin", "err", err)
+			return err
+		}
+		return saveChatLog(ctx, q, chatID, LogBranchCreated{
+			BranchID:         b.ID.String(),
+			OriginMessageIdx: b.Origin,
+		})
+	})
 }
 
-// XXX: Does not support optional fields in meta payload
-func findChatLog(ctx context.Context, q *db.Queries, chatID uuid.UUID) (log []LogEntry, _ error) {
-	rows, err := q.FindChatLog(ctx, chatID.String())
-	if err != nil {
-		return log, fmt.Errorf("failed to find chat log with %w", err)
-	}
-
-	// Used to match action & deserialzie
-	loggers := []ChatLogger{
-		LogBranchCreated{},
-		LogBranchMerged{},
-	}
-	var errs []error
-
-outer:
-	for _, row := range rows {
-		for _, logger := range loggers {
-			slog.Info("found chat log entry", "action", row.Action)
-			if row.Action != logger.getActionName() {
-				continue
-			}
-			meta, err := logger.fromEncoded(row.Meta)
-			if err != nil {
-				slog.Error("failed to unmarshal logger", "with", err)
-				errs = append(errs, err)
-				continue outer
-			}
-			slog.Info("adding log entry")
-			log = append(log, LogEntry{
-				Action: row.Action,
-				Meta:   meta,
-			})
-			continue outer
+// Moves origin of the branch & logs the rebase
+func rebaseBranch(ctx context.Context, q *db.Queries, chatID uuid.UUID, rebased LogBranchRebased) error {
+	return inTx(ctx, q, func(ctx context.Context, q *db.Queries) error {
+		err := q.UpdateChatBranchOrigin(ctx, db.UpdateChatBranchOriginParams{
+			Origin: int64(rebased.OriginMessageIdx),
+			ChatID: chatID.String(),
+			ID:     rebased.BranchID,
+		})
+		if err != nil {
+			slog.Error("failed to save branch origin", "err", err)
+			return err
 		}
-		errs = append(errs, fmt.Errorf("unknown log action %s", row.Action))
-	}
-
-	if len(errs) > 1 {
-		return log, fmt.Errorf("failed to deserialize logs with %w", errors.Join(errs...))
-	}
-	return log, nil
+		return saveChatLog(ctx, q, chatID, rebased)
+	})
 }
 
-func updateBranchMessages(ctx context.Context, q *db.Queries, chatID uuid.UUID, b Branch) error {
-	slog.Info("updating branch messages", "chatId", chatID, "id", b.ID)
-	encoded, err := json.Marshal(b.Messages)
-	if err != nil {
-		slog.Error("failed to encode branch messages", "err", err)
-		return err
-	}
-	err = q.SaveOrUpdateChatBranchMessages(ctx, db.SaveOrUpdateChatBranchMessagesParams{
-		ID:       b.ID.String(),
-		ChatID:   chatID.String(),
-		Messages: encoded,
+// Appends messages to main & logs the merge in the same transaction, the
+// merge position & amount are filled from the chat
+func mergeMessages(ctx context.Context, q *db.Queries, c *Chat, msgs []Message, merged LogBranchMerged) error {
+	merged.MergedAtMessageIdX = len(c.Messages) - 1
+	merged.MergedAmount = len(msgs)
+	c.Messages = slices.Concat(c.Messages, msgs)
+	return inTx(ctx, q, func(ctx context.Context, q *db.Queries) error {
+		if err := updateChatMessages(ctx, q, c); err != nil {
+			return err
+		}
+		return saveChatLog(ctx, q, c.ID, merged)
 	})
-	if err != nil {
-		slog.Error("failed to persist branch messages", "err", err)
-		return err
-	}
-	return nil
 }
 
-func generateMessage(ctx context.Context, g *genkit.Genkit, msgs []Message, mentioned []Chat, s chan<- string) (msg Message, err error) {
+// Partial answer is checkpointed after this many streamed chunks or this
+// much time since the previous checkpoint, whichever comes first
+const (
+	checkpointChunks   = 50
+	checkpointInterval = 5 * time.Second
+)
+
+// Streams the answer to s. When checkpoint isn't nil, it's periodically called
+// with the text generated so far
+func generateMessage(ctx context.Context, g *genkit.Genkit, msgs []Message, mentioned []Chat, s chan<- string, checkpoint func(ctx context.Context, text string)) (msg Message, err error) {
 	slog.Info("Starting message generation")
 	// Prepare messages
 	mapped := make([]*ai.Message, len(msgs))
@@ -288,11 +350,22 @@ func generateMessage(ctx context.Context, g *genkit.Genkit, msgs []Message, ment
 	}
 
 	// Request model
+	var partial strings.Builder
+	chunks, checkpointed := 0, time.Now()
 	resp, err := genkit.Generate(ctx, g,
 		ai.WithMessages(mapped...),
 		ai.WithDocs(docs...),
 		ai.WithStreaming(func(ctx context.Context, chunk *ai.ModelResponseChunk) error {
 			s <- chunk.Text()
+			if checkpoint == nil {
+				return nil
+			}
+			partial.WriteString(chunk.Text())
+			chunks++
+			if chunks >= checkpointChunks || time.Since(checkpointed) >= checkpointInterval {
+				checkpoint(ctx, partial.String())
+				chunks, checkpointed = 0, time.Now()
+			}
 			return nil
 		}),
 	)
@@ -302,32 +375,108 @@ func generateMessage(ctx context.Context, g *genkit.Genkit, msgs []Message, ment
 	slog.Info("model response", "length", len(resp.Text()))
 	msg.Role = "model"
 	msg.Text = resp.Text()
+	msg.Model = defaultModel
+	if resp.Usage != nil {
+		msg.InputTokens = resp.Usage.InputTokens
+		msg.OutputTokens = resp.Usage.OutputTokens
+	}
+	msg.CreatedAt = time.Now().UTC()
 	return
 }
 
 type HTMLMessage struct {
+	// Index of the message in main or the branch
+	Idx  int
 	Role string
 	Text template.HTML
+	// Short name of the squashed branch
+	SquashedFrom string
+	Incomplete   bool
 }
 
 func renderMessages(chat Chat) []HTMLMessage {
 	htmlMessages := make([]HTMLMessage, len(chat.Messages))
 
 	for i, v := range chat.Messages {
-		htmlMessages[i] = HTMLMessage{
-			Role: v.Role,
-			Text: markdownToHTML(v.Text),
-		}
+		htmlMessages[i] = renderMessage(v)
+		htmlMessages[i].Idx = i
 	}
 
 	return htmlMessages
 }
 
+// Upper bounds of cached rendered messages & of their total size, long
+// messages are evicted by the size before the amount is reached
+const (
+	maxCachedMessages = 10_000
+	maxCachedBytes    = 64 << 20
+)
+
+type cachedHTML struct {
+	text string
+	html template.HTML
+}
+
+// Rendered markdown of saved messages by their IDs. Text is kept to detect
+// messages which were changed under the same ID
+type htmlCache struct {
+	items map[string]cachedHTML
+	// IDs in the order of caching, the oldest are evicted first
+	order []string
+	size  int
+	// Total length of the cached texts & their HTML
+	bytes    int
+	maxBytes int
+	l        sync.Mutex
+}
+
+var messagesHTML = &htmlCache{
+	items:    make(map[string]cachedHTML),
+	size:     maxCachedMessages,
+	maxBytes: maxCachedBytes,
+}
+
+func (c *htmlCache) render(id, text string) template.HTML {
+	// Unsaved messages have no stable key
+	if id == "" {
+		return markdownToHTML(text)
+	}
+	c.l.Lock()
+	cached, ok := c.items[id]
+	c.l.Unlock()
+	if ok && cached.text == text {
+		return cached.html
+	}
+
+	html := markdownToHTML(text)
+	c.l.Lock()
+	defer c.l.Unlock()
+	if prev, ok := c.items[id]; ok {
+		c.bytes -= len(prev.text) + len(prev.html)
+	} else {
+		c.order = append(c.order, id)
+	}
+	c.items[id] = cachedHTML{text: text, html: html}
+	c.bytes += len(text) + len(html)
+	for len(c.order) > c.size || c.bytes > c.maxBytes {
+		evicted := c.items[c.order[0]]
+		c.bytes -= len(evicted.text) + len(evicted.html)
+		delete(c.items, c.order[0])
+		c.order = c.order[1:]
+	}
+	return html
+}
+
 func renderMessage(msg Message) HTMLMessage {
-	return HTMLMessage{
-		Role: msg.Role,
-		Text: markdownToHTML(msg.Text),
+	html := HTMLMessage{
+		Role:       msg.Role,
+		Text:       messagesHTML.render(msg.ID, msg.Text),
+		Incomplete: msg.Incomplete,
+	}
+	if msg.SquashedFrom != "" {
+		html.SquashedFrom = branchShortName(msg.SquashedFrom)
 	}
+	return html
 }
 
 func markdownToHTML(markdownStr string) template.HTML {
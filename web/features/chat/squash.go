@@ -0,0 +1,103 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// Summaries longer than this aren't squashes anymore
+const maxSquashSummaryLength = 20_000
+
+type generatedSquash struct {
+	Summary string `json:"summary"`
+}
+
+func genSquashSummary(ctx context.Context, g *genkit.Genkit, msgs []Message) (string, error) {
+	slog.Info("generating squash summary", "length", len(msgs))
+	prompt := genkit.LookupPrompt(g, "branch-squash")
+	if prompt == nil {
+		return "", fmt.Errorf("failed to find branch squash prompt")
+	}
+	var conversation strings.Builder
+	for _, msg := range msgs {
+		fmt.Fprintf(&conversation, "%s:\n%s\n\n", msg.Role, msg.Text)
+	}
+	resp, err := prompt.Execute(ctx, ai.WithInput(map[string]any{"conversation": conversation.String()}))
+	if err != nil {
+		return "", err
+	}
+	var output generatedSquash
+	if err := resp.Output(&output); err != nil {
+		return "", fmt.Errorf("failed to parse squash output with %w", err)
+	}
+	return strings.TrimSpace(output.Summary), nil
+}
+
+type squashPreviewView struct {
+	ChatID   string
+	BranchID string
+	Summary  string
+	BaseURI  string
+}
+
+// Generates summary of the whole branch to be edited before the merge
+func (h ChatHandler) postSquashPreview(w http.ResponseWriter, r *http.Request) {
+	// Validate data
+	chatID, err := deserID(w, r)
+	var errs []error
+	if err != nil {
+		errs = append(errs, err)
+	}
+	// Branch param always exists because of routing
+	branchID, _, err := deserBranchID(w, r)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		http.Error(w, errors.Join(errs...).Error(), http.StatusBadRequest)
+		return
+	}
+
+	q, err := h.getQueries(w, r)
+	if err != nil {
+		return
+	}
+
+	branch, err := findChatBranch(r.Context(), q, chatID, branchID)
+	if err == nil {
+		err = loadBranchState(r.Context(), q, chatID, &branch)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !branch.Mergeable() {
+		http.Error(w, "Branch has nothing to merge", http.StatusBadRequest)
+		return
+	}
+
+	summary, err := genSquashSummary(r.Context(), h.g, branch.Messages)
+	if err != nil {
+		slog.Error("failed to generate squash summary", "with", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = h.templates.Render(w, "squash-preview", squashPreviewView{
+		ChatID:   chatID.String(),
+		BranchID: branchID.String(),
+		Summary:  summary,
+		BaseURI:  h.baseURI,
+	})
+	if err != nil {
+		slog.Error("failed to render tempalte", "with", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
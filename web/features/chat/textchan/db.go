@@ -0,0 +1,155 @@
+package textchan
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"shellshift/internal/db"
+	"shellshift/internal/ulid"
+)
+
+const (
+	// Interval of checks for new chunks written by other instances
+	pollInterval = 250 * time.Millisecond
+	// Streams without changes for that long were abandoned by the crashed
+	// producer
+	staleAfter = time.Minute
+	// Done & stale streams are deleted after this period without changes
+	dbRetention = 10 * time.Minute
+)
+
+// Keeps streams in the database, so every instance sharing it can read them.
+// Text is stored as chunks, so readers fetch only the ones they haven't seen
+type DBBroker struct {
+	// Returns queries of the database which the ctx belongs to
+	queries func(ctx context.Context) (*db.Queries, error)
+}
+
+func NewDBBroker(queries func(ctx context.Context) (*db.Queries, error)) *DBBroker {
+	return &DBBroker{queries: queries}
+}
+
+func (b *DBBroker) Open(ctx context.Context, key string) (StreamWriter, error) {
+	q, err := b.queries(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Expired streams are dropped by the new ones, as well as chunks of the
+	// replaced stream
+	expired := time.Now().Add(-dbRetention).Unix()
+	err = q.DeleteExpiredStreamChunks(ctx, expired)
+	if err == nil {
+		err = q.DeleteExpiredStreams(ctx, expired)
+	}
+	if err == nil {
+		err = q.DeleteStreamChunks(ctx, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	w := &dbWriter{q: q, key: key, gen: ulid.New()}
+	err = q.OpenStream(ctx, db.OpenStreamParams{
+		ID:         key,
+		Generation: w.gen,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Writes chunks of the single stream generation
+type dbWriter struct {
+	q   *db.Queries
+	key string
+	gen string
+	// Length of the text written so far, position of the next chunk
+	written int
+}
+
+func (w *dbWriter) Append(ctx context.Context, chunk string) error {
+	// Stream replaced by another producer isn't written anymore
+	n, err := w.q.TouchStream(ctx, db.TouchStreamParams{
+		ID:         w.key,
+		Generation: w.gen,
+	})
+	if err != nil || n == 0 {
+		return err
+	}
+	err = w.q.SaveStreamChunk(ctx, db.SaveStreamChunkParams{
+		StreamID:   w.key,
+		Generation: w.gen,
+		Position:   int64(w.written),
+		Text:       chunk,
+	})
+	if err != nil {
+		return err
+	}
+	w.written += len(chunk)
+	return nil
+}
+
+func (w *dbWriter) Close(ctx context.Context) error {
+	return w.q.CloseStream(ctx, db.CloseStreamParams{
+		ID:         w.key,
+		Generation: w.gen,
+	})
+}
+
+// Offset should be at the chunk boundary, which it is when it's the sum of
+// the previously read texts
+func (b *DBBroker) Read(ctx context.Context, key string, offset int) (text string, done, ok bool, err error) {
+	q, err := b.queries(ctx)
+	if err != nil {
+		return "", false, false, err
+	}
+	row, ok, err := findStream(ctx, q, key)
+	if !ok || err != nil {
+		return "", false, ok, err
+	}
+	chunks, err := q.FindStreamChunks(ctx, db.FindStreamChunksParams{
+		StreamID:   key,
+		Generation: row.Generation,
+		Position:   int64(offset),
+	})
+	if err != nil {
+		return "", false, false, err
+	}
+	return strings.Join(chunks, ""), streamDone(row), true, nil
+}
+
+func (b *DBBroker) Status(ctx context.Context, key string) (done, ok bool, err error) {
+	q, err := b.queries(ctx)
+	if err != nil {
+		return false, false, err
+	}
+	row, ok, err := findStream(ctx, q, key)
+	return streamDone(row), ok, err
+}
+
+func findStream(ctx context.Context, q *db.Queries, key string) (_ db.FindStreamRow, ok bool, _ error) {
+	row, err := q.FindStream(ctx, key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return row, false, nil
+	}
+	if err != nil {
+		return row, false, err
+	}
+	return row, true, nil
+}
+
+func streamDone(row db.FindStreamRow) bool {
+	return row.Done || time.Since(time.Unix(row.UpdatedAt, 0)) > staleAfter
+}
+
+// Changes aren't announced across instances, so the stream is polled
+func (b *DBBroker) Wait(ctx context.Context, key string) <-chan struct{} {
+	c := make(chan struct{})
+	time.AfterFunc(pollInterval, func() { close(c) })
+	return c
+}
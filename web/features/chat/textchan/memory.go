@@ -0,0 +1,122 @@
+package textchan
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Time for which text of the done stream is kept for late readers
+const doneRetention = time.Minute
+
+type memoryStream struct {
+	text strings.Builder
+	done bool
+	// Closed and replaced on every change
+	changed chan struct{}
+}
+
+// Keeps streams in the process memory, suitable for a single instance
+type MemoryBroker struct {
+	l       sync.Mutex
+	streams map[string]*memoryStream
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		streams: make(map[string]*memoryStream),
+	}
+}
+
+func (b *MemoryBroker) Open(ctx context.Context, key string) (StreamWriter, error) {
+	b.l.Lock()
+	defer b.l.Unlock()
+	if prev, ok := b.streams[key]; ok {
+		close(prev.changed)
+	}
+	st := &memoryStream{changed: make(chan struct{})}
+	b.streams[key] = st
+	return &memoryWriter{b: b, key: key, st: st}, nil
+}
+
+// Writes the single stream, which is ignored once it's replaced
+type memoryWriter struct {
+	b   *MemoryBroker
+	key string
+	st  *memoryStream
+}
+
+func (w *memoryWriter) Append(ctx context.Context, chunk string) error {
+	w.b.l.Lock()
+	defer w.b.l.Unlock()
+	if w.b.streams[w.key] != w.st {
+		return nil
+	}
+	w.st.text.WriteString(chunk)
+	w.b.notify(w.st)
+	return nil
+}
+
+func (w *memoryWriter) Close(ctx context.Context) error {
+	w.b.l.Lock()
+	defer w.b.l.Unlock()
+	if w.b.streams[w.key] != w.st {
+		return nil
+	}
+	w.st.done = true
+	w.b.notify(w.st)
+
+	time.AfterFunc(doneRetention, func() {
+		w.b.l.Lock()
+		defer w.b.l.Unlock()
+		// Stream could be reopened meanwhile
+		if w.b.streams[w.key] == w.st {
+			delete(w.b.streams, w.key)
+		}
+	})
+	return nil
+}
+
+func (b *MemoryBroker) notify(st *memoryStream) {
+	close(st.changed)
+	st.changed = make(chan struct{})
+}
+
+func (b *MemoryBroker) Read(ctx context.Context, key string, offset int) (text string, done, ok bool, err error) {
+	b.l.Lock()
+	defer b.l.Unlock()
+	st, ok := b.streams[key]
+	if !ok {
+		return "", false, false, nil
+	}
+	return tail(st.text.String(), offset), st.done, true, nil
+}
+
+func (b *MemoryBroker) Status(ctx context.Context, key string) (done, ok bool, err error) {
+	b.l.Lock()
+	defer b.l.Unlock()
+	st, ok := b.streams[key]
+	if !ok {
+		return false, false, nil
+	}
+	return st.done, true, nil
+}
+
+func (b *MemoryBroker) Wait(ctx context.Context, key string) <-chan struct{} {
+	b.l.Lock()
+	defer b.l.Unlock()
+	st, ok := b.streams[key]
+	if !ok {
+		// Nothing to wait for
+		c := make(chan struct{})
+		close(c)
+		return c
+	}
+	return st.changed
+}
+
+// Text after the offset, which is beyond the text of the reopened stream
+func tail(text string, offset int) string {
+	return text[min(offset, len(text)):]
+}
@@ -1,33 +1,115 @@
 package textchan
 
 import (
+	"context"
 	"log/slog"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+// Chunks produced within this interval are passed to the broker at once, so
+// brokers aren't written for every token
+const appendInterval = 100 * time.Millisecond
+
+// Stores text of the streams, so subscribers can read it while the producer
+// writes. Streams are identified by keys unique across topics
+type Broker interface {
+	// Starts the stream, the previous one with the same key is replaced and
+	// writes of its producer are ignored from then on
+	Open(ctx context.Context, key string) (StreamWriter, error)
+	// Returns text accumulated after the offset, ok is false when there is
+	// no such stream
+	Read(ctx context.Context, key string, offset int) (text string, done, ok bool, err error)
+	// Same as Read without the text
+	Status(ctx context.Context, key string) (done, ok bool, err error)
+	// Returns channel which is ready when the stream may have changed
+	Wait(ctx context.Context, key string) <-chan struct{}
+}
+
+// Writes of the single stream opened by the producer
+type StreamWriter interface {
+	Append(ctx context.Context, chunk string) error
+	// Marks the stream done, its text is still readable for a while
+	Close(ctx context.Context) error
+}
+
 type TextChan struct {
-	l sync.RWMutex
+	topic  string
+	broker Broker
+
+	l sync.Mutex
+	// Streams written by this process
 	c map[uuid.UUID]*Stream
 }
 
+// Written by the single producer, chunks are passed to the broker
 type Stream struct {
 	Chunks chan string
-	Done   chan struct{}
+	id     uuid.UUID
 }
 
-func New() *TextChan {
+func New(topic string, broker Broker) *TextChan {
 	return &TextChan{
-		c: make(map[uuid.UUID]*Stream),
+		topic:  topic,
+		broker: broker,
+		c:      make(map[uuid.UUID]*Stream),
 	}
 }
 
-func (s *TextChan) Alloc(id uuid.UUID) *Stream {
+func (s *TextChan) key(id uuid.UUID) string {
+	return s.topic + ":" + id.String()
+}
+
+// Starts stream, ctx should outlive the request which started the producer
+func (s *TextChan) Alloc(ctx context.Context, id uuid.UUID) *Stream {
+	key := s.key(id)
+	w, err := s.broker.Open(ctx, key)
+	if err != nil {
+		slog.Error("failed to open stream", "key", key, "with", err)
+	}
 	st := &Stream{
 		Chunks: make(chan string, 100),
-		Done:   make(chan struct{}),
+		id:     id,
 	}
+	go func() {
+		ticker := time.NewTicker(appendInterval)
+		defer ticker.Stop()
+
+		// Chunks are still drained when the stream failed to open
+		var pending strings.Builder
+		flush := func() {
+			if pending.Len() == 0 || w == nil {
+				return
+			}
+			if err := w.Append(ctx, pending.String()); err != nil {
+				slog.Error("failed to append stream chunk", "key", key, "with", err)
+			}
+			pending.Reset()
+		}
+		for {
+			select {
+			case chunk, ok := <-st.Chunks:
+				if ok {
+					pending.WriteString(chunk)
+					continue
+				}
+				flush()
+				if w == nil {
+					return
+				}
+				if err := w.Close(ctx); err != nil {
+					slog.Error("failed to close stream", "key", key, "with", err)
+				}
+				return
+			case <-ticker.C:
+				flush()
+			}
+		}
+	}()
+
 	s.l.Lock()
 	defer s.l.Unlock()
 	s.c[id] = st
@@ -35,30 +117,78 @@ func (s *TextChan) Alloc(id uuid.UUID) *Stream {
 	return st
 }
 
-func (s *TextChan) Get(id uuid.UUID) (st *Stream, ok bool) {
-	s.l.RLock()
-	defer s.l.RUnlock()
-	st, ok = s.c[id]
-	return
+// Ends the stream, the one allocated with the same id by the next producer
+// isn't affected
+func (s *TextChan) Free(st *Stream) {
+	s.l.Lock()
+	if s.c[st.id] == st {
+		delete(s.c, st.id)
+	}
+	s.l.Unlock()
+
+	// Remaining chunks are passed to the broker before close
+	close(st.Chunks)
+
+	slog.Info("textchan was freed", "id", st.id)
 }
 
-func (s *TextChan) Free(id uuid.UUID) {
-	// Get current state
-	s.l.RLock()
-	st, ok := s.c[id]
-	if !ok {
-		return
+// Whether the stream is written by any process
+func (s *TextChan) Generating(ctx context.Context, id uuid.UUID) bool {
+	done, ok, err := s.broker.Status(ctx, s.key(id))
+	if err != nil {
+		slog.Error("failed to read stream", "id", id, "with", err)
+		return false
 	}
-	s.l.RUnlock()
+	return ok && !done
+}
 
-	// Mark chan done
-	st.Done <- struct{}{}
-	close(st.Chunks)
+// Returns channel with all chunks of the stream, text accumulated before
+// the subscription comes as the first chunk. Chunks which weren't read in
+// time are merged, so slow subscribers never block the producer. Channel is
+// closed when the stream is done or ctx ends, ok is false when there is no
+// stream being written
+func (s *TextChan) Subscribe(ctx context.Context, id uuid.UUID) (_ <-chan string, ok bool) {
+	key := s.key(id)
+	done, ok, err := s.broker.Status(ctx, key)
+	if err != nil {
+		slog.Error("failed to read stream", "key", key, "with", err)
+		return nil, false
+	}
+	if !ok || done {
+		return nil, false
+	}
 
-	// Delete entry
-	s.l.Lock()
-	defer s.l.Unlock()
-	delete(s.c, id)
+	out := make(chan string)
+	go func() {
+		defer close(out)
+
+		offset := 0
+		for {
+			// Waiting starts before the read, so no change is missed
+			changed := s.broker.Wait(ctx, key)
+			chunk, done, ok, err := s.broker.Read(ctx, key, offset)
+			if err != nil {
+				slog.Error("failed to read stream", "key", key, "with", err)
+				return
+			}
+			if chunk != "" {
+				offset += len(chunk)
+				select {
+				case out <- chunk:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if done || !ok {
+				return
+			}
 
-	slog.Info("textchan was freed", "id", id)
+			select {
+			case <-changed:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, true
 }
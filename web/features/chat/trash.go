@@ -0,0 +1,374 @@
+package chat
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+
+	"shellshift/internal/db"
+)
+
+// How often trashed chats & branches are checked for expiration
+const purgeInterval = time.Hour
+
+var errNotTrashed = errors.New("not in the trash")
+
+type trashView struct {
+	Chats     []trashedChat
+	Branches  []trashedBranch
+	Retention int
+	BaseURI   string
+	GraphURI  string
+}
+
+type trashedChat struct {
+	ID        string
+	Title     string
+	DeletedAt time.Time
+	PurgeAt   time.Time
+}
+
+type trashedBranch struct {
+	ID        string
+	Name      string
+	ChatID    string
+	ChatTitle string
+	DeletedAt time.Time
+	PurgeAt   time.Time
+}
+
+func (h ChatHandler) getTrash(w http.ResponseWriter, r *http.Request) {
+	q, err := h.getQueries(w, r)
+	if err != nil {
+		return
+	}
+
+	// Expired entries shouldn't be shown even if background purge wasn't run yet
+	if err := purgeTrash(r.Context(), q, h.trashRetention); err != nil {
+		slog.Error("failed to purge trash", "with", err)
+	}
+
+	chats, err := q.FindTrashedChats(r.Context())
+	if err != nil && err != sql.ErrNoRows {
+		slog.Error("failed to find trashed chats", "with", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	branches, err := q.FindTrashedChatBranches(r.Context())
+	if err != nil && err != sql.ErrNoRows {
+		slog.Error("failed to find trashed branches", "with", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	view := trashView{
+		Chats:     make([]trashedChat, len(chats)),
+		Branches:  make([]trashedBranch, len(branches)),
+		Retention: int(h.trashRetention.Hours() / 24),
+		BaseURI:   h.baseURI,
+		GraphURI:  h.graphURI,
+	}
+	for i, c := range chats {
+		deletedAt := time.Unix(c.DeletedAt.Int64, 0)
+		view.Chats[i] = trashedChat{
+			ID:        c.ID,
+			Title:     c.Title,
+			DeletedAt: deletedAt,
+			PurgeAt:   deletedAt.Add(h.trashRetention),
+		}
+	}
+	for i, b := range branches {
+		deletedAt := time.Unix(b.DeletedAt.Int64, 0)
+		name := b.Name
+		if name == "" {
+			name = branchShortName(b.ID)
+		}
+		view.Branches[i] = trashedBranch{
+			ID:        b.ID,
+			Name:      name,
+			ChatID:    b.ChatID,
+			ChatTitle: b.Title,
+			DeletedAt: deletedAt,
+			PurgeAt:   deletedAt.Add(h.trashRetention),
+		}
+	}
+
+	if err := h.templates.Render(w, "trash", view); err != nil {
+		slog.Error("failed to render trash", "with", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (h ChatHandler) postChatRestore(w http.ResponseWriter, r *http.Request) {
+	// Validate id
+	id, err := deserID(w, r)
+	if err != nil {
+		return
+	}
+
+	q, err := h.getQueries(w, r)
+	if err != nil {
+		return
+	}
+
+	if err := checkChatTrashed(w, r, q, id); err != nil {
+		return
+	}
+
+	// Restore chat
+	err = q.RestoreChat(r.Context(), id.String())
+	if err != nil {
+		slog.Error("failed to restore chat", "id", id, "with", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h ChatHandler) deleteChatTrash(w http.ResponseWriter, r *http.Request) {
+	// Validate id
+	id, err := deserID(w, r)
+	if err != nil {
+		return
+	}
+
+	q, err := h.getQueries(w, r)
+	if err != nil {
+		return
+	}
+
+	if err := checkChatTrashed(w, r, q, id); err != nil {
+		return
+	}
+
+	// Delete chat permanently
+	err = q.DeleteChat(r.Context(), id.String())
+	if err != nil {
+		slog.Error("failed to delete chat", "id", id, "with", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h ChatHandler) deleteBranch(w http.ResponseWriter, r *http.Request) {
+	// Validate data
+	chatID, err := deserID(w, r)
+	var errs []error
+	if err != nil {
+		errs = append(errs, err)
+	}
+	// Branch param always exists because of routing
+	branchID, _, err := deserBranchID(w, r)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		http.Error(w, errors.Join(errs...).Error(), http.StatusBadRequest)
+		return
+	}
+
+	q, err := h.getQueries(w, r)
+	if err != nil {
+		return
+	}
+
+	// Move branch to the trash
+	err = q.TrashChatBranch(r.Context(), db.TrashChatBranchParams{
+		ChatID: chatID.String(),
+		ID:     branchID.String(),
+	})
+	if err != nil {
+		slog.Error("failed to trash branch", "id", branchID, "with", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Redirect
+	w.Header().Set("HX-Redirect", h.baseURI+"/"+chatID.String())
+}
+
+func (h ChatHandler) postBranchRestore(w http.ResponseWriter, r *http.Request) {
+	// Validate data
+	chatID, err := deserID(w, r)
+	var errs []error
+	if err != nil {
+		errs = append(errs, err)
+	}
+	// Branch param always exists because of routing
+	branchID, _, err := deserBranchID(w, r)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		http.Error(w, errors.Join(errs...).Error(), http.StatusBadRequest)
+		return
+	}
+
+	q, err := h.getQueries(w, r)
+	if err != nil {
+		return
+	}
+
+	if err := checkBranchTrashed(w, r, q, chatID, branchID); err != nil {
+		return
+	}
+
+	// Restore branch
+	err = q.RestoreChatBranch(r.Context(), db.RestoreChatBranchParams{
+		ChatID: chatID.String(),
+		ID:     branchID.String(),
+	})
+	if err != nil {
+		slog.Error("failed to restore branch", "id", branchID, "with", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h ChatHandler) deleteBranchTrash(w http.ResponseWriter, r *http.Request) {
+	// Validate data
+	chatID, err := deserID(w, r)
+	var errs []error
+	if err != nil {
+		errs = append(errs, err)
+	}
+	// Branch param always exists because of routing
+	branchID, _, err := deserBranchID(w, r)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		http.Error(w, errors.Join(errs...).Error(), http.StatusBadRequest)
+		return
+	}
+
+	q, err := h.getQueries(w, r)
+	if err != nil {
+		return
+	}
+
+	if err := checkBranchTrashed(w, r, q, chatID, branchID); err != nil {
+		return
+	}
+
+	err = deleteBranchForever(r.Context(), q, chatID, branchID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// Deletes branch permanently with its messages & comments. Messages &
+// comments are deleted only while the branch row is in the trash, so the row
+// goes last
+func deleteBranchForever(ctx context.Context, q *db.Queries, chatID, branchID uuid.UUID) error {
+	return inTx(ctx, q, func(ctx context.Context, q *db.Queries) error {
+		err := q.DeleteBranchComments(ctx, db.DeleteBranchCommentsParams{
+			ChatID:   chatID.String(),
+			BranchID: branchID.String(),
+		})
+		if err != nil {
+			slog.Error("failed to delete branch comments", "id", branchID, "with", err)
+			return err
+		}
+		err = q.DeleteBranchMessages(ctx, db.DeleteBranchMessagesParams{
+			ChatID:   chatID.String(),
+			BranchID: branchID.String(),
+		})
+		if err != nil {
+			slog.Error("failed to delete branch messages", "id", branchID, "with", err)
+			return err
+		}
+		err = q.DeleteChatBranch(ctx, db.DeleteChatBranchParams{
+			ChatID: chatID.String(),
+			ID:     branchID.String(),
+		})
+		if err != nil {
+			slog.Error("failed to delete branch", "id", branchID, "with", err)
+		}
+		return err
+	})
+}
+
+// Writes 404 unless the chat is in the trash
+func checkChatTrashed(w http.ResponseWriter, r *http.Request, q *db.Queries, id uuid.UUID) error {
+	chat, err := q.FindChat(r.Context(), id.String())
+	if errors.Is(err, sql.ErrNoRows) || err == nil && !chat.DeletedAt.Valid {
+		http.Error(w, "Chat isn't in the trash", http.StatusNotFound)
+		return errNotTrashed
+	}
+	if err != nil {
+		slog.Error("failed to find chat", "id", id, "with", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+	return err
+}
+
+// Writes 404 unless the branch is in the trash, main is never trashed
+func checkBranchTrashed(w http.ResponseWriter, r *http.Request, q *db.Queries, chatID, branchID uuid.UUID) error {
+	if branchID == mainBranchID {
+		http.Error(w, "Main branch can't be in the trash", http.StatusBadRequest)
+		return errNotTrashed
+	}
+	branch, err := q.FindChatBranch(r.Context(), db.FindChatBranchParams{
+		ID:     branchID.String(),
+		ChatID: chatID.String(),
+	})
+	if errors.Is(err, sql.ErrNoRows) || err == nil && !branch.DeletedAt.Valid {
+		http.Error(w, "Branch isn't in the trash", http.StatusNotFound)
+		return errNotTrashed
+	}
+	if err != nil {
+		slog.Error("failed to find branch", "id", branchID, "with", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+	return err
+}
+
+// Permanently deletes chats & branches which stayed in the trash longer than retention
+func purgeTrash(ctx context.Context, q *db.Queries, retention time.Duration) error {
+	expired := sql.NullInt64{
+		Int64: time.Now().Add(-retention).Unix(),
+		Valid: true,
+	}
+	// Comments & messages are found by their branch rows, so nothing is left
+	// orphaned when only a part of the purge fails
+	return inTx(ctx, q, func(ctx context.Context, q *db.Queries) error {
+		var errs []error
+		if err := q.PurgeChats(ctx, expired); err != nil {
+			errs = append(errs, err)
+		}
+		if err := q.PurgeChatBranchComments(ctx, expired); err != nil {
+			errs = append(errs, err)
+		}
+		if err := q.PurgeChatBranchMessages(ctx, expired); err != nil {
+			errs = append(errs, err)
+		}
+		if err := q.PurgeChatBranches(ctx, expired); err != nil {
+			errs = append(errs, err)
+		}
+		return errors.Join(errs...)
+	})
+}
+
+// Periodically purges trash of every known user database
+func runTrashPurge(ctx context.Context, dbF *db.Factory, retention time.Duration) {
+	t := time.NewTicker(purgeInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			for userID, q := range dbF.All() {
+				if err := purgeTrash(ctx, q, retention); err != nil {
+					slog.Error("failed to purge trash", "userId", userID, "with", err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package chat
+
+import (
+	"context"
+
+	"shellshift/internal/db"
+)
+
+type txKey struct{}
+
+// Side effects of the running transaction, they're done once it's committed
+type txEffects struct {
+	fns []func()
+}
+
+// Runs fn in a transaction, so either all of its changes are saved or none.
+// Calls made inside of fn with its ctx & queries join the same transaction
+func inTx(ctx context.Context, q *db.Queries, fn func(ctx context.Context, q *db.Queries) error) error {
+	if _, ok := ctx.Value(txKey{}).(*txEffects); ok {
+		return fn(ctx, q)
+	}
+	effects := &txEffects{}
+	err := q.Tx(ctx, func(q *db.Queries) error {
+		return fn(context.WithValue(ctx, txKey{}, effects), q)
+	})
+	if err != nil {
+		return err
+	}
+	for _, f := range effects.fns {
+		f()
+	}
+	return nil
+}
+
+// Runs f once the transaction of ctx is committed, right away outside of one.
+// Feed events & job wake-ups shouldn't announce changes which may be rolled back
+func afterCommit(ctx context.Context, f func()) {
+	if effects, ok := ctx.Value(txKey{}).(*txEffects); ok {
+		effects.fns = append(effects.fns, f)
+		return
+	}
+	f()
+}
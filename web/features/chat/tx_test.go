@@ -0,0 +1,382 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"shellshift/internal/db"
+	"shellshift/internal/db/dbtest"
+	"shellshift/web/features/auth"
+	"shellshift/web/features/chat/feed"
+)
+
+// Saves the chat with a branch based on its last message & a comment on the
+// branch
+func seedChat(t *testing.T, q *db.Queries) (Chat, Branch) {
+	t.Helper()
+	ctx := context.Background()
+	chat := Chat{
+		ID:    uuid.New(),
+		Title: "Chat",
+		Messages: []Message{
+			{Text: "Question", Role: "user"},
+			{Text: "Answer", Role: "model"},
+		},
+	}
+	if err := saveChat(ctx, q, chat); err != nil {
+		t.Fatalf("failed to save chat with %s", err)
+	}
+	branch := Branch{
+		ID: uuid.New(),
+		Messages: []Message{
+			{Text: "Branch question", Role: "user"},
+			{Text: "Branch answer", Role: "model"},
+		},
+		Origin: 1,
+	}
+	if err := createBranch(ctx, q, chat.ID, &branch); err != nil {
+		t.Fatalf("failed to create branch with %s", err)
+	}
+	err := q.SaveComment(ctx, db.SaveCommentParams{
+		ID:         uuid.NewString(),
+		ChatID:     chat.ID.String(),
+		BranchID:   branch.ID.String(),
+		MessageIdx: 1,
+		Text:       "Comment",
+	})
+	if err != nil {
+		t.Fatalf("failed to save comment with %s", err)
+	}
+	return chat, branch
+}
+
+// Returns ctx of the user which receives feed events published after commits
+func subscribeFeed(t *testing.T) (context.Context, <-chan feed.Event) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	userID := uuid.NewString()
+	return context.WithValue(ctx, auth.UserIDKey, userID), userFeed.Subscribe(ctx, userID)
+}
+
+// Events are published synchronously, so all of them are buffered already
+func publishedEvents(c <-chan feed.Event) (types []string) {
+	for {
+		select {
+		case e := <-c:
+			types = append(types, e.Type)
+		default:
+			return types
+		}
+	}
+}
+
+func TestInTxRollsBackOnFailedStatement(t *testing.T) {
+	tests := []struct {
+		name string
+		// Statement which fails after the others were run
+		failKind  string
+		failTable string
+		run       func(ctx context.Context, q *db.Queries, chat Chat, branch Branch) error
+		// Events published when the operation is committed
+		wantEvents []string
+	}{
+		{
+			name:      "merge",
+			failKind:  "INSERT",
+			failTable: "chat_log",
+			run: func(ctx context.Context, q *db.Queries, chat Chat, branch Branch) error {
+				chat, err := findChat(ctx, q, chat.ID)
+				if err != nil {
+					return err
+				}
+				return mergeMessages(ctx, q, &chat, branch.Messages, LogBranchMerged{
+					MergeID:     uuid.NewString(),
+					BranchID:    branch.ID.String(),
+					MessageIdxs: []int{0, 1},
+				})
+			},
+			wantEvents: []string{feedBranchMerged},
+		},
+		{
+			name:      "create branch",
+			failKind:  "INSERT",
+			failTable: "chat_log",
+			run: func(ctx context.Context, q *db.Queries, chat Chat, branch Branch) error {
+				return createBranch(ctx, q, chat.ID, &Branch{
+					ID:       uuid.New(),
+					Messages: []Message{{Text: "Another question", Role: "user"}},
+					Origin:   0,
+				})
+			},
+			wantEvents: []string{feedBranchCreated},
+		},
+		{
+			name:      "delete branch from trash",
+			failKind:  "DELETE",
+			failTable: "message",
+			run: func(ctx context.Context, q *db.Queries, chat Chat, branch Branch) error {
+				err := q.TrashChatBranch(ctx, db.TrashChatBranchParams{
+					ChatID: chat.ID.String(),
+					ID:     branch.ID.String(),
+				})
+				if err != nil {
+					return err
+				}
+				return deleteBranchForever(ctx, q, chat.ID, branch.ID)
+			},
+		},
+		{
+			name:      "purge trash",
+			failKind:  "DELETE",
+			failTable: "chat_branch",
+			run: func(ctx context.Context, q *db.Queries, chat Chat, branch Branch) error {
+				err := q.TrashChatBranch(ctx, db.TrashChatBranchParams{
+					ChatID: chat.ID.String(),
+					ID:     branch.ID.String(),
+				})
+				if err != nil {
+					return err
+				}
+				// Negative retention makes the branch expired right away
+				return purgeTrash(ctx, q, -time.Hour)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, conn := dbtest.New(t)
+			chat, branch := seedChat(t, q)
+			ctx, events := subscribeFeed(t)
+
+			// Operation is run as one transaction, so failed statement
+			// rolls back everything which was run before
+			before := dbtest.Dump(t, conn)
+			restore := dbtest.FailOn(t, conn, tt.failKind, tt.failTable)
+			err := inTx(ctx, q, func(ctx context.Context, q *db.Queries) error {
+				return tt.run(ctx, q, chat, branch)
+			})
+			if err == nil {
+				t.Fatal("operation succeeded, want injected failure")
+			}
+			if after := dbtest.Dump(t, conn); !reflect.DeepEqual(before, after) {
+				t.Errorf("database changed after rollback\nbefore: %v\nafter:  %v", before, after)
+			}
+			if got := publishedEvents(events); len(got) > 0 {
+				t.Errorf("events published after rollback: %v", got)
+			}
+
+			// Same operation without the failure changes the database, so
+			// the rollback above undid the real changes
+			restore()
+			err = inTx(ctx, q, func(ctx context.Context, q *db.Queries) error {
+				return tt.run(ctx, q, chat, branch)
+			})
+			if err != nil {
+				t.Fatalf("operation failed with %s", err)
+			}
+			if after := dbtest.Dump(t, conn); reflect.DeepEqual(before, after) {
+				t.Error("database didn't change after commit")
+			}
+			if got := publishedEvents(events); !reflect.DeepEqual(got, tt.wantEvents) {
+				t.Errorf("events published after commit = %v, want %v", got, tt.wantEvents)
+			}
+		})
+	}
+}
+
+func TestInTxNested(t *testing.T) {
+	errInner := errors.New("inner failed")
+	errOuter := errors.New("outer failed")
+	tests := []struct {
+		name      string
+		innerErr  error
+		outerErr  error
+		wantErr   error
+		wantSaved bool
+	}{
+		{
+			name:      "commits inner & outer changes together",
+			wantSaved: true,
+		},
+		{
+			name:     "outer failure rolls back inner changes",
+			outerErr: errOuter,
+			wantErr:  errOuter,
+		},
+		{
+			name:     "inner failure rolls back outer changes",
+			innerErr: errInner,
+			wantErr:  errInner,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			q, _ := dbtest.New(t)
+			outerChat := Chat{ID: uuid.New(), Title: "Outer"}
+			innerChat := Chat{ID: uuid.New(), Title: "Inner"}
+
+			err := inTx(ctx, q, func(ctx context.Context, outerQ *db.Queries) error {
+				if err := saveChat(ctx, outerQ, outerChat); err != nil {
+					return err
+				}
+				err := inTx(ctx, outerQ, func(ctx context.Context, innerQ *db.Queries) error {
+					// Queries bound to another transaction would block on
+					// the single connection, the same ones are expected
+					if innerQ != outerQ {
+						t.Error("nested inTx got queries of another transaction")
+					}
+					if err := saveChat(ctx, innerQ, innerChat); err != nil {
+						return err
+					}
+					return tt.innerErr
+				})
+				if err != nil {
+					return err
+				}
+				return tt.outerErr
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("inTx() error = %v, want %v", err, tt.wantErr)
+			}
+
+			for _, c := range []Chat{outerChat, innerChat} {
+				_, err := findChat(ctx, q, c.ID)
+				if saved := err == nil; saved != tt.wantSaved {
+					t.Errorf("%s chat saved = %t, want %t", c.Title, saved, tt.wantSaved)
+				}
+			}
+		})
+	}
+}
+
+func TestAfterCommit(t *testing.T) {
+	errFn := errors.New("fn failed")
+	tests := []struct {
+		name string
+		// Table which can't be inserted into
+		failOn string
+		// Registers f with afterCommit & returns the result of the whole call
+		run      func(ctx context.Context, q *db.Queries, f func()) error
+		wantRuns int
+	}{
+		{
+			name: "runs right away outside of transaction",
+			run: func(ctx context.Context, q *db.Queries, f func()) error {
+				afterCommit(ctx, f)
+				return nil
+			},
+			wantRuns: 1,
+		},
+		{
+			name: "runs once after commit",
+			run: func(ctx context.Context, q *db.Queries, f func()) error {
+				return inTx(ctx, q, func(ctx context.Context, q *db.Queries) error {
+					afterCommit(ctx, f)
+					return saveChat(ctx, q, Chat{ID: uuid.New()})
+				})
+			},
+			wantRuns: 1,
+		},
+		{
+			name: "nested runs after outer commit",
+			run: func(ctx context.Context, q *db.Queries, f func()) error {
+				return inTx(ctx, q, func(ctx context.Context, q *db.Queries) error {
+					return inTx(ctx, q, func(ctx context.Context, q *db.Queries) error {
+						afterCommit(ctx, f)
+						return nil
+					})
+				})
+			},
+			wantRuns: 1,
+		},
+		{
+			name: "skipped when fn fails",
+			run: func(ctx context.Context, q *db.Queries, f func()) error {
+				return inTx(ctx, q, func(ctx context.Context, q *db.Queries) error {
+					afterCommit(ctx, f)
+					return errFn
+				})
+			},
+		},
+		{
+			name:   "skipped when later statement fails",
+			failOn: "chat",
+			run: func(ctx context.Context, q *db.Queries, f func()) error {
+				return inTx(ctx, q, func(ctx context.Context, q *db.Queries) error {
+					afterCommit(ctx, f)
+					return saveChat(ctx, q, Chat{ID: uuid.New()})
+				})
+			},
+		},
+		{
+			name: "skipped when outer fails after nested succeeds",
+			run: func(ctx context.Context, q *db.Queries, f func()) error {
+				return inTx(ctx, q, func(ctx context.Context, q *db.Queries) error {
+					err := inTx(ctx, q, func(ctx context.Context, q *db.Queries) error {
+						afterCommit(ctx, f)
+						return nil
+					})
+					if err != nil {
+						return err
+					}
+					return errFn
+				})
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			q, conn := dbtest.New(t)
+			if tt.failOn != "" {
+				dbtest.FailOn(t, conn, "INSERT", tt.failOn)
+			}
+
+			runs := 0
+			err := tt.run(ctx, q, func() { runs++ })
+			if wantErr := tt.wantRuns == 0; (err != nil) != wantErr {
+				t.Fatalf("run() error = %v, want error %t", err, wantErr)
+			}
+			if runs != tt.wantRuns {
+				t.Errorf("callback runs = %d, want %d", runs, tt.wantRuns)
+			}
+		})
+	}
+}
+
+func TestDeleteBranchForeverKeepsLiveBranches(t *testing.T) {
+	tests := []struct {
+		name     string
+		branchID func(branch Branch) uuid.UUID
+	}{
+		{
+			name:     "branch which isn't trashed",
+			branchID: func(branch Branch) uuid.UUID { return branch.ID },
+		},
+		{
+			name:     "main branch",
+			branchID: func(branch Branch) uuid.UUID { return mainBranchID },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			q, conn := dbtest.New(t)
+			chat, branch := seedChat(t, q)
+
+			before := dbtest.Dump(t, conn)
+			if err := deleteBranchForever(ctx, q, chat.ID, tt.branchID(branch)); err != nil {
+				t.Fatalf("deleteBranchForever() failed with %s", err)
+			}
+			if after := dbtest.Dump(t, conn); !reflect.DeepEqual(before, after) {
+				t.Errorf("database changed\nbefore: %v\nafter:  %v", before, after)
+			}
+		})
+	}
+}
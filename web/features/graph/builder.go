@@ -30,6 +30,8 @@ type NodeData struct {
 	UpdatedAt int    `json:"-"`
 	Parent    string `json:"parent"`
 	Level     int    `json:"level"`
+	Pinned    bool   `json:"pinned"`
+	Archived  bool   `json:"archived"`
 }
 
 type Edge struct {
@@ -43,7 +45,28 @@ type EdgeData struct {
 	Target string `json:"target"`
 }
 
-func buildGraph(chats []db.FindChatTagsRow, mentions []db.FindChatMentionsRow) []any {
+// Defines which chats are shown on the graph
+type Filter struct {
+	// Show archived chats along with the active ones
+	Archived bool
+	// Show pinned chats only
+	PinnedOnly bool
+}
+
+func (f Filter) includes(chat db.FindChatTagsRow) bool {
+	if chat.ArchivedAt.Valid && !f.Archived {
+		return false
+	}
+	if !chat.PinnedAt.Valid && f.PinnedOnly {
+		return false
+	}
+	return true
+}
+
+func buildGraph(chats []db.FindChatTagsRow, mentions []db.FindChatMentionsRow, filter Filter) []any {
+	chats = slices.DeleteFunc(slices.Clone(chats), func(c db.FindChatTagsRow) bool {
+		return !filter.includes(c)
+	})
 	if len(chats) == 0 {
 		return []any{}
 	}
@@ -70,6 +93,8 @@ func buildGraph(chats []db.FindChatTagsRow, mentions []db.FindChatMentionsRow) [
 					Title:     v.Title,
 					UpdatedAt: int(v.UpdatedAt),
 					Parent:    parent,
+					Pinned:    v.PinnedAt.Valid,
+					Archived:  v.ArchivedAt.Valid,
 				},
 			})
 		}
@@ -105,6 +130,12 @@ func buildGraph(chats []db.FindChatTagsRow, mentions []db.FindChatMentionsRow) [
 		})
 	}
 
+	// Edges are allowed only between shown chats
+	mentions = slices.DeleteFunc(slices.Clone(mentions), func(m db.FindChatMentionsRow) bool {
+		return !slices.ContainsFunc(chats, func(c db.FindChatTagsRow) bool { return c.ID == m.SourceID }) ||
+			!slices.ContainsFunc(chats, func(c db.FindChatTagsRow) bool { return c.ID == m.TargetID })
+	})
+
 	graph = slices.Concat(graph, mentionEdges(mentions))
 	return graph
 }
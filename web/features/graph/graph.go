@@ -32,6 +32,7 @@ func InitMux(dbF *db.Factory, protector *auth.ProtectionMiddleware, chatURI stri
 type Graph struct {
 	ChatURI  string
 	Graph    []any
+	Filter   Filter
 	Keybinds web.KeybindsTable
 }
 
@@ -55,9 +56,15 @@ func (h GraphHandler) getGraph(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	filter := Filter{
+		Archived:   r.URL.Query().Get("archived") == "true",
+		PinnedOnly: r.URL.Query().Get("pinned") == "true",
+	}
+
 	err = h.t.Render(w, "index", Graph{
 		ChatURI:  h.chatURI,
-		Graph:    buildGraph(chats, mentions),
+		Graph:    buildGraph(chats, mentions, filter),
+		Filter:   filter,
 		Keybinds: web.Keybinds,
 	})
 